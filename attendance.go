@@ -0,0 +1,141 @@
+package lm
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// attendanceTransitions are the statuses an Attendance can move to from each status. Attended and no-show
+// can be swapped to correct a mistake, and a class the user cancelled can be planned again, but a class the
+// gym cancelled can't change
+var attendanceTransitions = map[string][]string{
+	AttendancePlanned:         {AttendanceAttended, AttendanceNoShow, AttendanceCancelledByGym, AttendanceCancelledByUser},
+	AttendanceAttended:        {AttendanceNoShow},
+	AttendanceNoShow:          {AttendanceAttended},
+	AttendanceCancelledByUser: {AttendancePlanned},
+	AttendanceCancelledByGym:  {},
+}
+
+// activeStatuses are the statuses of classes which take up time in a user's schedule
+var activeStatuses = []string{AttendancePlanned, AttendanceAttended}
+
+// StoreUserClassStatus will store a class against a user in the database with the status provided
+// The class is stored even if it clashes with the user's other planned or attended classes, and any clashes are
+// returned as warnings. A class can only be stored as attended once it has started. A class the user already has
+// is left unchanged, use UpdateAttendanceStatus to change it
+func StoreUserClassStatus(user string, classID string, status string, dbConfig *Config) ([]Conflict, error) {
+	if _, ok := attendanceTransitions[status]; !ok {
+		return nil, fmt.Errorf("Unknown attendance status '%s'", status)
+	}
+
+	// Get class from ID
+	var c GymClass
+	err := dbConfig.DB.One("UUID", classID, &c)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "class": classID}).Error("Failed to find class from bolt db")
+		return nil, err
+	}
+	if status == AttendanceAttended && !c.Started(dbConfig.now()) {
+		return nil, fmt.Errorf("Unable to mark class %s as attended before it starts", classID)
+	}
+
+	// If it already exists don't add it again
+	var a Attendance
+	err = dbConfig.DB.One("ID", attendanceID(user, c.UUID), &a)
+	if err == nil {
+		log.WithFields(log.Fields{"class": c.UUID, "user": user}).Info("Class already exists for user")
+		return []Conflict{}, nil
+	} else if err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to find classes for user")
+		return nil, err
+	}
+
+	conflicts := []Conflict{}
+	if containsFold(activeStatuses, status) {
+		existing, err := QueryUserClassesByStatus(user, dbConfig, activeStatuses...)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = conflictsWith(c, existing, dbConfig.TravelGap)
+		for _, conflict := range conflicts {
+			log.WithFields(log.Fields{"first": conflict.First.UUID, "second": conflict.Second.UUID, "type": conflict.Type, "user": user}).Warn("Class clashes with another of the user's classes")
+		}
+	}
+
+	now := dbConfig.now()
+	a = Attendance{
+		ID:        attendanceID(user, c.UUID),
+		UserID:    user,
+		ClassUUID: c.UUID,
+		Status:    status,
+		Timestamp: now,
+		History:   []StatusChange{{To: status, Timestamp: now}},
+	}
+	err = dbConfig.DB.Save(&a)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "class": classID, "user": user}).Error("Failed to store user classes")
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// UpdateAttendanceStatus moves a user's class to a new status, recording the change in its history
+// Only the changes in attendanceTransitions are allowed, and a class can't be attended before it starts
+func UpdateAttendanceStatus(user string, classID string, status string, dbConfig *Config) (Attendance, error) {
+	var a Attendance
+	err := dbConfig.DB.One("ID", attendanceID(user, classID), &a)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user, "class": classID}).Error("Failed to find user class when updating its status")
+		return Attendance{}, err
+	}
+	if !CanChangeAttendanceStatus(a.Status, status) {
+		return Attendance{}, fmt.Errorf("Unable to change a class from %s to %s", a.Status, status)
+	}
+	if status == AttendanceAttended {
+		var c GymClass
+		err = dbConfig.DB.One("UUID", classID, &c)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "class": classID}).Error("Failed to find class from bolt db")
+			return Attendance{}, err
+		}
+		if !c.Started(dbConfig.now()) {
+			return Attendance{}, fmt.Errorf("Unable to mark class %s as attended before it starts", classID)
+		}
+	}
+	now := dbConfig.now()
+	a.History = append(a.History, StatusChange{From: a.Status, To: status, Timestamp: now})
+	a.Status = status
+	a.Timestamp = now
+	err = dbConfig.DB.Save(&a)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user, "class": classID}).Error("Failed to update status of user class")
+		return Attendance{}, err
+	}
+	log.Infof("Changed class %s for user %s to %s", classID, user, status)
+	return a, nil
+}
+
+// CanChangeAttendanceStatus returns whether an Attendance can move from one status to another
+func CanChangeAttendanceStatus(from string, to string) bool {
+	for _, s := range attendanceTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// queryAttendance returns all of a user's Attendance records
+func queryAttendance(user string, dbConfig *Config) ([]Attendance, error) {
+	var attendance []Attendance
+	err := dbConfig.DB.Find("UserID", user, &attendance)
+	if err == storm.ErrNotFound {
+		return []Attendance{}, nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to get user classes")
+		return []Attendance{}, err
+	}
+	return attendance, nil
+}
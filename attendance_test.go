@@ -0,0 +1,93 @@
+package lm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanChangeAttendanceStatus(t *testing.T) {
+	var tests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{AttendancePlanned, AttendanceAttended, true},
+		{AttendancePlanned, AttendanceNoShow, true},
+		{AttendancePlanned, AttendanceCancelledByGym, true},
+		{AttendancePlanned, AttendanceCancelledByUser, true},
+		{AttendanceAttended, AttendanceNoShow, true},
+		{AttendanceNoShow, AttendanceAttended, true},
+		{AttendanceCancelledByUser, AttendancePlanned, true},
+		{AttendanceAttended, AttendancePlanned, false},
+		{AttendanceAttended, AttendanceCancelledByUser, false},
+		{AttendanceCancelledByGym, AttendancePlanned, false},
+		{AttendancePlanned, AttendancePlanned, false},
+		{AttendancePlanned, "unknown", false},
+		{"unknown", AttendanceAttended, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, CanChangeAttendanceStatus(test.from, test.to), "%s to %s", test.from, test.to)
+	}
+}
+
+func TestAttendanceStatus(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+	_, err = StoreClasses(testClasses, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes %s", err)
+	}
+
+	for _, c := range testClasses[:5] {
+		_, err = StoreUserClassStatus("123", c.UUID, AttendancePlanned, testConfig)
+		assert.NoError(t, err)
+	}
+	_, err = StoreUserClassStatus("123", testClasses[5].UUID, "maybe", testConfig)
+	assert.Error(t, err, "Expected an error for an unknown status")
+
+	a, err := UpdateAttendanceStatus("123", testClasses[0].UUID, AttendanceAttended, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, AttendanceAttended, a.Status)
+	if assert.Len(t, a.History, 2) {
+		assert.Equal(t, StatusChange{From: AttendancePlanned, To: AttendanceAttended, Timestamp: a.Timestamp}, a.History[1])
+	}
+	_, err = UpdateAttendanceStatus("123", testClasses[1].UUID, AttendanceAttended, testConfig)
+	assert.NoError(t, err)
+	_, err = UpdateAttendanceStatus("123", testClasses[2].UUID, AttendanceNoShow, testConfig)
+	assert.NoError(t, err)
+	_, err = UpdateAttendanceStatus("123", testClasses[3].UUID, AttendanceCancelledByGym, testConfig)
+	assert.NoError(t, err)
+	_, err = UpdateAttendanceStatus("123", testClasses[3].UUID, AttendancePlanned, testConfig)
+	assert.Error(t, err, "A class cancelled by the gym can't be planned again")
+	_, err = UpdateAttendanceStatus("123", testClasses[5].UUID, AttendanceAttended, testConfig)
+	assert.Error(t, err, "Expected an error for a class the user doesn't have")
+	_, err = UpdateAttendanceStatus("123", testClasses[4].UUID, AttendanceAttended, testConfig)
+	assert.Error(t, err, "A class can't be attended before it starts")
+	_, err = StoreUserClassStatus("456", testClasses[5].UUID, AttendanceAttended, testConfig)
+	assert.Error(t, err, "A class can't be stored as attended before it starts")
+
+	// Only attended classes count towards the statistics
+	stats, err := QueryUserStatistics("123", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.TotalClasses)
+	assert.Equal(t, 1, stats.PlannedClasses)
+	assert.Equal(t, 1, stats.CancelledClasses)
+	assert.Equal(t, 1, stats.NoShows)
+	assert.InDelta(t, 1.0/3.0, stats.NoShowRate, 0.0001)
+
+	classes, err := QueryUserClassesByStatus("123", testConfig, AttendancePlanned, AttendanceNoShow)
+	assert.NoError(t, err)
+	assert.Len(t, classes, 2)
+	classes, err = QueryUserClasses("123", testConfig)
+	assert.NoError(t, err)
+	assert.Len(t, classes, 5)
+}
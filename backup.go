@@ -0,0 +1,218 @@
+package lm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// Backup writes a copy of the database to w. The copy is taken from a read transaction so the database can
+// continue to be used while the backup is written
+func Backup(w io.Writer, dbConfig *Config) error {
+	tx, err := dbConfig.DB.Bolt.Begin(false)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction for backup")
+		return err
+	}
+	defer tx.Rollback()
+	n, err := tx.WriteTo(w)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to backup database")
+		return err
+	}
+	log.Infof("Wrote %d bytes to backup", n)
+	return nil
+}
+
+// Restore replaces the database with a backup read from r. The backup is checked before it replaces the
+// current database, which is left untouched if the backup is invalid. Nothing else may use the database while
+// it is being restored
+func Restore(r io.Reader, dbConfig *Config) error {
+	dir := filepath.Dir(dbConfig.DBPath)
+	tmp, err := ioutil.TempFile(dir, ".restore-")
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to create file to restore into")
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		log.WithFields(log.Fields{"error": err}).Error("Failed to read backup")
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = validateBackup(tmp.Name())
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Backup is not a valid database")
+		return err
+	}
+
+	// Keep the current database until the restored one has been opened
+	previous := dbConfig.DBPath + ".previous"
+	err = dbConfig.DB.Close()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to close database before restoring")
+		return err
+	}
+	err = os.Rename(dbConfig.DBPath, previous)
+	if err != nil && !os.IsNotExist(err) {
+		return reopen(dbConfig, err)
+	}
+	err = os.Rename(tmp.Name(), dbConfig.DBPath)
+	if err != nil {
+		os.Rename(previous, dbConfig.DBPath)
+		return reopen(dbConfig, err)
+	}
+	restored, err := OpenConfig(dbConfig.DBPath)
+	if err != nil {
+		os.Rename(previous, dbConfig.DBPath)
+		return reopen(dbConfig, err)
+	}
+	dbConfig.DB = restored.DB
+	os.Remove(previous)
+	log.Infof("Restored database %s from backup", dbConfig.DBPath)
+	return nil
+}
+
+// BackupToFile writes a backup of the database into dir, named after the database and the current time, and
+// returns the path of the backup
+func BackupToFile(dir string, dbConfig *Config) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "dir": dir}).Error("Failed to create backup directory")
+		return "", err
+	}
+	// Write to a temporary file first so a partial backup is never mistaken for a complete one
+	tmp, err := ioutil.TempFile(dir, ".backup-")
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "dir": dir}).Error("Failed to create backup file")
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = Backup(tmp, dbConfig)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s%s.db", backupPrefix(dbConfig), time.Now().UTC().Format("20060102T150405.000000000Z")))
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "path": path}).Error("Failed to save backup file")
+		return "", err
+	}
+	return path, nil
+}
+
+// ScheduleBackups writes a backup into dir every interval, keeping only the most recent keep backups
+// Calling the returned function stops any further backups
+func ScheduleBackups(dir string, interval time.Duration, keep int, dbConfig *Config) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				path, err := BackupToFile(dir, dbConfig)
+				if err != nil {
+					// Nothing waits on a scheduled backup so its failure is only seen in the log
+					log.WithFields(log.Fields{"error": err, "dir": dir}).Error("Failed to run scheduled backup")
+					continue
+				}
+				log.Infof("Backed up database to %s", path)
+				err = rotateBackups(dir, backupPrefix(dbConfig), keep)
+				if err != nil {
+					log.WithFields(log.Fields{"error": err, "dir": dir}).Error("Failed to remove old backups")
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// rotateBackups removes all but the most recent keep backups in dir
+func rotateBackups(dir string, prefix string, keep int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), prefix) && strings.HasSuffix(f.Name(), ".db") {
+			backups = append(backups, f.Name())
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	// Backups are named by time so sorting by name puts the oldest first
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		log.Infof("Removed old backup %s", name)
+	}
+	return nil
+}
+
+func backupPrefix(dbConfig *Config) string {
+	base := filepath.Base(dbConfig.DBPath)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "-"
+}
+
+// validateBackup checks the file at path is a consistent database. It is only used on the copy being restored
+// as opening it may write to it
+func validateBackup(path string) error {
+	db, err := storm.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Bolt.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var errs []string
+	for err := range tx.Check() {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// reopen opens the database again after a failed restore and returns the error that caused the failure
+func reopen(dbConfig *Config, cause error) error {
+	log.WithFields(log.Fields{"error": cause}).Error("Failed to restore database")
+	c, err := OpenConfig(dbConfig.DBPath)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to reopen database after failed restore")
+		return err
+	}
+	dbConfig.DB = c.DB
+	return cause
+}
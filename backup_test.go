@@ -0,0 +1,81 @@
+package lm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRestore(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer func() { testConfig.DB.Close() }()
+	storeExportData(t, testConfig)
+
+	var backup bytes.Buffer
+	err = Backup(&backup, testConfig)
+	assert.NoError(t, err, "Failed to backup database")
+
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	err = Restore(&backup, testConfig)
+	assert.NoError(t, err, "Failed to restore database")
+
+	classes, err := QueryUserClasses(testUser.ID, testConfig)
+	if err != nil {
+		t.Errorf("Failed to get user classes %s", err)
+	}
+	assert.Equal(t, 3, len(classes), "Did not restore user classes")
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer func() { testConfig.DB.Close() }()
+	storeExportData(t, testConfig)
+
+	err = Restore(strings.NewReader("not a database"), testConfig)
+	assert.Error(t, err, "Expected an error restoring an invalid backup")
+
+	classes, err := QueryUserClasses(testUser.ID, testConfig)
+	assert.NoError(t, err, "Database was not usable after a failed restore")
+	assert.Equal(t, 3, len(classes), "Database was changed by a failed restore")
+}
+
+func TestRotateBackups(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer testConfig.DB.Close()
+
+	dir, err := ioutil.TempDir("", "gymbackup")
+	if err != nil {
+		t.Errorf("Failed to create backup directory %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 4; i++ {
+		_, err = BackupToFile(dir, testConfig)
+		assert.NoError(t, err, "Failed to backup database to file")
+	}
+	err = rotateBackups(dir, backupPrefix(testConfig), 2)
+	assert.NoError(t, err, "Failed to rotate backups")
+
+	backups, err := filepath.Glob(filepath.Join(dir, "gym-*.db"))
+	if err != nil {
+		t.Errorf("Failed to list backups %s", err)
+	}
+	assert.Equal(t, 2, len(backups), "Did not keep the expected number of backups")
+}
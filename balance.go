@@ -0,0 +1,205 @@
+package lm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Categories are the categories of the classes in Classes
+var Categories = []string{CategoryCardio, CategoryStrength, CategoryFlexibility, CategoryCore}
+
+// TargetMix is the share of classes a user aims to do in each category. The shares are weights so they don't
+// need to add up to one
+type TargetMix map[string]float64
+
+// DefaultTargetMix is the mix of categories used when the configuration doesn't set one
+var DefaultTargetMix = TargetMix{
+	CategoryCardio:      0.4,
+	CategoryStrength:    0.3,
+	CategoryFlexibility: 0.2,
+	CategoryCore:        0.1,
+}
+
+// DefaultBalanceWeeks is the number of weeks that the training balance in UserStatistics covers
+const DefaultBalanceWeeks = 4
+
+// BalanceTolerance is how far a category's share can be from its target before it is an imbalance
+const BalanceTolerance = 0.1
+
+// The types of Imbalance
+const (
+	ImbalanceMissing = "missing"
+	ImbalanceUnder   = "under"
+	ImbalanceOver    = "over"
+)
+
+// CategoryBalance describes the classes a user did in a category compared with their target share
+// Difference is the share less the target, and LastClass is the most recent class in the category
+// including ones before the period
+type CategoryBalance struct {
+	Category   string    `json:"category"`
+	Count      int       `json:"count"`
+	Share      float64   `json:"share"`
+	Target     float64   `json:"target"`
+	Difference float64   `json:"difference"`
+	LastClass  time.Time `json:"lastClass"`
+}
+
+// Imbalance describes a category a user is doing too much or too little of
+type Imbalance struct {
+	Category string `json:"category"`
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+}
+
+// TrainingBalance describes a user's mix of categories over a period. Classes which aren't in a known
+// category are left out
+type TrainingBalance struct {
+	Period     Interval          `json:"period"`
+	Total      int               `json:"total"`
+	Categories []CategoryBalance `json:"categories"`
+	Imbalances []Imbalance       `json:"imbalances"`
+}
+
+// QueryTrainingBalance returns the balance of the classes a user attended during the period against the
+// configured target mix
+func QueryTrainingBalance(user string, period Interval, dbConfig *Config) (TrainingBalance, error) {
+	c, err := QueryUserClassesByStatus(user, dbConfig, AttendanceAttended)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to get classes for training balance")
+		return TrainingBalance{}, err
+	}
+	return c.Balance(period, dbConfig.targetMix()), nil
+}
+
+// RankCategories ranks the categories by the number of classes in them, see rankBy
+func (g GymClasses) RankCategories(n int) []Ranked {
+	return g.rankBy(n, func(c GymClass) string { return c.Category() })
+}
+
+// Balance compares the mix of categories of the classes starting within the period against the target mix
+// A category in the target without any classes in the period is missing, and one whose share is more than
+// BalanceTolerance from its target is under or over
+func (g GymClasses) Balance(period Interval, target TargetMix) TrainingBalance {
+	b := TrainingBalance{Period: period, Categories: []CategoryBalance{}, Imbalances: []Imbalance{}}
+	target = target.normalise()
+
+	counts := make(map[string]int)
+	last := make(map[string]time.Time)
+	for _, c := range g {
+		category := c.Category()
+		if category == "" || !c.StartDateTime.Before(period.End) {
+			continue
+		}
+		if c.StartDateTime.After(last[category]) {
+			last[category] = c.StartDateTime
+		}
+		if !c.StartDateTime.Before(period.Start) {
+			counts[category]++
+			b.Total++
+		}
+	}
+
+	for _, category := range balanceCategories(target, counts) {
+		cb := CategoryBalance{Category: category, Count: counts[category], Target: target[category], LastClass: last[category]}
+		if b.Total > 0 {
+			cb.Share = float64(cb.Count) / float64(b.Total)
+		}
+		cb.Difference = cb.Share - cb.Target
+		b.Categories = append(b.Categories, cb)
+
+		switch {
+		case cb.Target > 0 && cb.Count == 0:
+			since := period.Start
+			if !cb.LastClass.IsZero() {
+				since = cb.LastClass
+			}
+			b.Imbalances = append(b.Imbalances, Imbalance{
+				Category: category,
+				Type:     ImbalanceMissing,
+				Message:  fmt.Sprintf("No %s classes in %s", category, formatSpan(period.End.Sub(since))),
+			})
+		case b.Total > 0 && cb.Difference < -BalanceTolerance:
+			b.Imbalances = append(b.Imbalances, Imbalance{
+				Category: category,
+				Type:     ImbalanceUnder,
+				Message:  fmt.Sprintf("Only %d%% of classes were %s, the target is %d%%", percent(cb.Share), category, percent(cb.Target)),
+			})
+		case b.Total > 0 && cb.Difference > BalanceTolerance:
+			b.Imbalances = append(b.Imbalances, Imbalance{
+				Category: category,
+				Type:     ImbalanceOver,
+				Message:  fmt.Sprintf("%d%% of classes were %s, the target is %d%%", percent(cb.Share), category, percent(cb.Target)),
+			})
+		}
+	}
+	return b
+}
+
+// normalise returns the mix scaled so its shares add up to one, ignoring negative shares. Categories are lower
+// cased to match the categories of classes, adding together any which only differ by case. A mix without any
+// positive shares is replaced by DefaultTargetMix
+func (m TargetMix) normalise() TargetMix {
+	if m.total() == 0 {
+		m = DefaultTargetMix
+	}
+	total := m.total()
+	normalised := make(TargetMix, len(m))
+	for category, share := range m {
+		if share > 0 {
+			normalised[strings.ToLower(strings.TrimSpace(category))] += share / total
+		}
+	}
+	return normalised
+}
+
+func (m TargetMix) total() float64 {
+	total := 0.0
+	for _, share := range m {
+		if share > 0 {
+			total += share
+		}
+	}
+	return total
+}
+
+// balanceCategories returns the categories in Categories followed by any others in the target or with
+// classes, in alphabetical order
+func balanceCategories(target TargetMix, counts map[string]int) []string {
+	categories := append([]string{}, Categories...)
+	var others []string
+	add := func(category string) {
+		if !containsFold(categories, category) && !containsFold(others, category) {
+			others = append(others, category)
+		}
+	}
+	for category := range target {
+		add(category)
+	}
+	for category := range counts {
+		add(category)
+	}
+	sort.Strings(others)
+	return append(categories, others...)
+}
+
+// formatSpan describes a period as a whole number of weeks, or days when it is shorter than two weeks
+func formatSpan(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	switch {
+	case days >= 14:
+		return fmt.Sprintf("%d weeks", days/7)
+	case days == 1:
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// percent returns a share as a rounded percentage
+func percent(share float64) int {
+	return int(share*100 + 0.5)
+}
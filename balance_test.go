@@ -0,0 +1,72 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalance(t *testing.T) {
+	period := Interval{Start: time.Date(2018, 4, 2, 0, 0, 0, 0, time.UTC), End: time.Date(2018, 4, 16, 0, 0, 0, 0, time.UTC)}
+	classes := GymClasses{
+		morningClass("YOGA", 3, 26),
+		morningClass("RPM", 4, 2), morningClass("RPM", 4, 4), morningClass("RPM", 4, 6),
+		morningClass("RPM", 4, 9), morningClass("RPM", 4, 11),
+		morningClass("BODYPUMP", 4, 3), morningClass("BODYPUMP", 4, 10), morningClass("BODYPUMP", 4, 12),
+		morningClass("CXWORX", 4, 5), morningClass("CXWORX", 4, 13),
+		// Classes without a category and after the period are left out
+		morningClass("ZUMBA", 4, 7),
+		morningClass("RPM", 4, 16),
+	}
+
+	b := classes.Balance(period, DefaultTargetMix)
+	assert.Equal(t, period, b.Period)
+	assert.Equal(t, 10, b.Total)
+	if assert.Len(t, b.Categories, 4) {
+		assert.Equal(t, CategoryCardio, b.Categories[0].Category)
+		assert.Equal(t, 5, b.Categories[0].Count)
+		assert.Equal(t, 0.5, b.Categories[0].Share)
+		assert.InDelta(t, 0.4, b.Categories[0].Target, 0.0001)
+		assert.Equal(t, CategoryFlexibility, b.Categories[2].Category)
+		assert.Equal(t, 0, b.Categories[2].Count)
+		assert.Equal(t, time.Date(2018, 3, 26, 6, 0, 0, 0, time.UTC), b.Categories[2].LastClass)
+	}
+	assert.Equal(t, []Imbalance{{CategoryFlexibility, ImbalanceMissing, "No flexibility classes in 2 weeks"}}, b.Imbalances)
+
+	// Targets are weights, and categories without a target are never missing
+	b = classes.Balance(period, TargetMix{CategoryCardio: 1, CategoryStrength: 1})
+	assert.Equal(t, []Imbalance{
+		{CategoryStrength, ImbalanceUnder, "Only 30% of classes were strength, the target is 50%"},
+		{CategoryCore, ImbalanceOver, "20% of classes were core, the target is 0%"},
+	}, b.Imbalances)
+
+	// Categories in the target are matched ignoring case
+	b = classes.Balance(period, TargetMix{"Cardio": 0.5, "STRENGTH": 0.3, " Core ": 0.2})
+	if assert.Len(t, b.Categories, 4) {
+		assert.Equal(t, CategoryCardio, b.Categories[0].Category)
+		assert.InDelta(t, 0.5, b.Categories[0].Target, 0.0001)
+		assert.InDelta(t, 0.2, b.Categories[3].Target, 0.0001)
+	}
+	assert.Equal(t, []Imbalance{}, b.Imbalances)
+
+	b = GymClasses{}.Balance(period, nil)
+	assert.Equal(t, 0, b.Total)
+	assert.Len(t, b.Imbalances, 4)
+	assert.Equal(t, "No cardio classes in 2 weeks", b.Imbalances[0].Message)
+
+	assert.Equal(t, []string{CategoryCardio, CategoryStrength, CategoryCore, CategoryFlexibility}, rankedKeys(classes.RankCategories(0)))
+}
+
+func TestFormatSpan(t *testing.T) {
+	day := 24 * time.Hour
+	assert.Equal(t, "0 days", formatSpan(time.Hour))
+	assert.Equal(t, "1 day", formatSpan(day))
+	assert.Equal(t, "13 days", formatSpan(13*day))
+	assert.Equal(t, "3 weeks", formatSpan(23*day))
+}
+
+// morningClass returns a class starting at 6am UTC on a day in 2018
+func morningClass(name string, month time.Month, day int) GymClass {
+	return GymClass{Name: name, StartDateTime: time.Date(2018, month, day, 6, 0, 0, 0, time.UTC)}
+}
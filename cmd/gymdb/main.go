@@ -0,0 +1,212 @@
+// Command gymdb manages the data stored in a gym database
+//
+// Usage:
+//
+//	gymdb [-db gym.db] export [-format jsonl|csv] [-o file] [-dir dir]
+//	gymdb [-db gym.db] import [-format jsonl|csv] [-i file] [-dir dir]
+//	gymdb [-db gym.db] backup [-o file] [-dir dir -every 24h -keep 7]
+//	gymdb [-db gym.db] restore -i file
+//	gymdb [-db gym.db] userdata -user id [-format json|zip] [-o file]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	gym "github.com/ryankscott/go_gymclass"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gymdb [-db path] <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  export    write the database as JSON Lines or CSV\n")
+	fmt.Fprintf(os.Stderr, "  import    load a JSON Lines or CSV export into the database\n")
+	fmt.Fprintf(os.Stderr, "  backup    copy the database while it is in use, optionally on a schedule\n")
+	fmt.Fprintf(os.Stderr, "  restore   replace the database with a backup\n")
+	fmt.Fprintf(os.Stderr, "  userdata  write everything stored about a user as JSON or a ZIP of CSV files\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbPath := flag.String("db", "gym.db", "path to the gym database")
+	flag.Usage = usage
+	flag.Parse()
+	// Keep stdout free for exports
+	log.SetOutput(os.Stderr)
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	config, err := gym.OpenConfig(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %s\n", err)
+		os.Exit(1)
+	}
+	// Restore replaces config.DB so the database is only looked up when closing it
+	defer func() { config.DB.Close() }()
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "export":
+		err = export(config, args)
+	case "import":
+		err = load(config, args)
+	case "backup":
+		err = backup(config, args)
+	case "restore":
+		err = restore(config, args)
+	case "userdata":
+		err = userData(config, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func export(config *gym.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "export format, either jsonl or csv")
+	out := fs.String("o", "-", "file to write a jsonl export to")
+	dir := fs.String("dir", ".", "directory to write a csv export to")
+	fs.Parse(args)
+
+	switch *format {
+	case "jsonl":
+		w := io.Writer(os.Stdout)
+		if *out != "-" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		return gym.ExportJSONLines(w, config)
+	case "csv":
+		return gym.ExportCSV(*dir, config)
+	}
+	return errors.New("Unknown format " + *format)
+}
+
+func load(config *gym.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "import format, either jsonl or csv")
+	in := fs.String("i", "-", "file to read a jsonl export from")
+	dir := fs.String("dir", ".", "directory to read a csv export from")
+	fs.Parse(args)
+
+	var result gym.ImportResult
+	var err error
+	switch *format {
+	case "jsonl":
+		r := io.Reader(os.Stdin)
+		if *in != "-" {
+			f, err := os.Open(*in)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		result, err = gym.ImportJSONLines(r, config)
+	case "csv":
+		result, err = gym.ImportCSV(*dir, config)
+	default:
+		return errors.New("Unknown format " + *format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d classes, %d users, %d attendance records, %d saved searches and %d profiles (%d skipped)\n", result.Classes, result.Users, result.Attendance, result.SavedSearches, result.Profiles, result.Skipped)
+	return nil
+}
+
+func backup(config *gym.Config, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "", "file to write the backup to")
+	dir := fs.String("dir", "backups", "directory to write scheduled backups to")
+	every := fs.Duration("every", 0, "interval between scheduled backups, runs until interrupted")
+	keep := fs.Int("keep", 7, "number of scheduled backups to keep")
+	fs.Parse(args)
+
+	if *every > 0 {
+		stop := gym.ScheduleBackups(*dir, *every, *keep, config)
+		defer stop()
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		return nil
+	}
+	if *out == "" {
+		path, err := gym.BackupToFile(*dir, config)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Backed up database to %s\n", path)
+		}
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = gym.Backup(f, config)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func restore(config *gym.Config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("i", "", "backup file to restore from")
+	fs.Parse(args)
+
+	if *in == "" {
+		return errors.New("No backup file given, please use -i")
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gym.Restore(f, config)
+}
+
+func userData(config *gym.Config, args []string) error {
+	fs := flag.NewFlagSet("userdata", flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user to export")
+	format := fs.String("format", "json", "export format, either json or zip")
+	out := fs.String("o", "-", "file to write the export to")
+	fs.Parse(args)
+
+	if *user == "" {
+		return errors.New("No user given, please use -user")
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "json":
+		return gym.ExportUserData(w, *user, config)
+	case "zip":
+		return gym.ExportUserDataZIP(w, *user, config)
+	}
+	return errors.New("Unknown format " + *format)
+}
@@ -0,0 +1,93 @@
+package lm
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The types of Conflict between two classes
+const (
+	ConflictOverlap = "overlap"
+	ConflictTravel  = "travel"
+)
+
+// Conflict describes two classes in a user's schedule that can't both be attended. Classes overlap when the
+// second starts before the first ends, and are too close when there isn't enough time to travel between gyms
+// Gap is the time from the end of First to the start of Second, which is negative when they overlap
+type Conflict struct {
+	Type   string        `json:"type"`
+	First  GymClass      `json:"first"`
+	Second GymClass      `json:"second"`
+	Gap    time.Duration `json:"gap"`
+}
+
+// Conflicts returns every pair of classes which overlap, or which are at different gyms without travelGap
+// between them. Pairs are ordered by the start of the first class and then the second
+func (g GymClasses) Conflicts(travelGap time.Duration) []Conflict {
+	sorted := make(GymClasses, len(g))
+	copy(sorted, g)
+	sort.Stable(ByStartDateTime(sorted))
+
+	conflicts := []Conflict{}
+	for i, first := range sorted {
+		for _, second := range sorted[i+1:] {
+			// Classes are sorted by start so no later class can conflict with first either
+			if !second.StartDateTime.Before(first.EndDateTime.Add(travelGap)) {
+				break
+			}
+			if c, ok := classConflict(first, second, travelGap); ok {
+				conflicts = append(conflicts, c)
+			}
+		}
+	}
+	return conflicts
+}
+
+// QueryUserConflicts returns the conflicts between the classes a user has planned or attended, using the
+// configuration's TravelGap as the time needed to get between gyms
+func QueryUserConflicts(user string, dbConfig *Config) ([]Conflict, error) {
+	classes, err := QueryUserClassesByStatus(user, dbConfig, activeStatuses...)
+	if err != nil {
+		return []Conflict{}, err
+	}
+	return classes.Conflicts(dbConfig.TravelGap), nil
+}
+
+// conflictsWith returns the conflicts between class and the classes provided
+func conflictsWith(class GymClass, classes GymClasses, travelGap time.Duration) []Conflict {
+	conflicts := []Conflict{}
+	for _, other := range classes {
+		if other.UUID == class.UUID {
+			continue
+		}
+		first, second := other, class
+		if class.StartDateTime.Before(other.StartDateTime) {
+			first, second = class, other
+		}
+		if c, ok := classConflict(first, second, travelGap); ok {
+			conflicts = append(conflicts, c)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].First.StartDateTime.Before(conflicts[j].First.StartDateTime)
+	})
+	return conflicts
+}
+
+// classConflict returns the conflict between two classes where first doesn't start after second
+func classConflict(first GymClass, second GymClass, travelGap time.Duration) (Conflict, bool) {
+	gap := second.StartDateTime.Sub(first.EndDateTime)
+	c := Conflict{First: first, Second: second, Gap: gap}
+	switch {
+	case gap < 0:
+		c.Type = ConflictOverlap
+	case first.Gym != second.Gym && gap < travelGap:
+		c.Type = ConflictTravel
+	default:
+		return Conflict{}, false
+	}
+	log.WithFields(log.Fields{"first": first.UUID, "second": second.UUID, "type": c.Type}).Debug("Found conflicting classes")
+	return c, true
+}
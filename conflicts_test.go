@@ -0,0 +1,87 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflicts(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2018, 4, 3, hour, minute, 0, 0, gymLocation) }
+	class := func(uuid string, gym string, start time.Time, length time.Duration) GymClass {
+		return GymClass{UUID: uuid, Gym: gym, Name: "RPM", StartDateTime: start, EndDateTime: start.Add(length)}
+	}
+	classes := GymClasses{
+		class("pump", "city", at(6, 0), 55*time.Minute),
+		class("rpm", "city", at(6, 30), 45*time.Minute),
+		class("cxworx", "city", at(7, 15), 30*time.Minute),
+		class("sprint", "britomart", at(8, 0), 30*time.Minute),
+		class("yoga", "takapuna", at(18, 0), time.Hour),
+	}
+	type pair struct {
+		Type   string
+		First  string
+		Second string
+	}
+	pairs := func(conflicts []Conflict) []pair {
+		p := []pair{}
+		for _, c := range conflicts {
+			p = append(p, pair{c.Type, c.First.UUID, c.Second.UUID})
+		}
+		return p
+	}
+
+	// Classes at the same gym can be back to back
+	assert.Equal(t, []pair{{ConflictOverlap, "pump", "rpm"}}, pairs(classes.Conflicts(0)))
+	// but 15 minutes isn't long enough to get to another gym
+	assert.Equal(t, []pair{{ConflictOverlap, "pump", "rpm"}, {ConflictTravel, "cxworx", "sprint"}}, pairs(classes.Conflicts(20*time.Minute)))
+
+	conflicts := conflictsWith(classes[1], classes, 20*time.Minute)
+	if assert.Len(t, conflicts, 1) {
+		assert.Equal(t, -25*time.Minute, conflicts[0].Gap)
+	}
+	assert.Empty(t, conflictsWith(classes[4], classes, time.Hour))
+}
+
+func TestStoreUserClassConflicts(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+	testConfig.TravelGap = 30 * time.Minute
+	_, err = StoreClasses(testClasses, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes %s", err)
+	}
+
+	conflicts, err := StoreUserClass("123", testClasses[0].UUID, testConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+	// The RPM class at the same time as BODYPUMP is still stored but with a warning
+	conflicts, err = StoreUserClass("123", testClasses[1].UUID, testConfig)
+	assert.NoError(t, err)
+	if assert.Len(t, conflicts, 1) {
+		assert.Equal(t, ConflictOverlap, conflicts[0].Type)
+	}
+	// Going from city to britomart straight after BODYPUMP leaves no time to travel
+	conflicts, err = StoreUserClass("123", testClasses[5].UUID, testConfig)
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 2)
+	for _, c := range conflicts {
+		assert.Equal(t, ConflictTravel, c.Type)
+	}
+
+	classes, err := QueryUserClasses("123", testConfig)
+	assert.NoError(t, err)
+	assert.Len(t, classes, 3)
+	conflicts, err = QueryUserConflicts("123", testConfig)
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 3)
+}
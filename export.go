@@ -0,0 +1,595 @@
+package lm
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// The types of record that can be exported and imported
+const (
+	RecordGym         = "gym"
+	RecordClass       = "class"
+	RecordUser        = "user"
+	RecordAttendance  = "attendance"
+	RecordSavedSearch = "saved_search"
+	RecordProfile     = "profile"
+)
+
+// ExportRecord describes a single line of a JSON Lines export
+type ExportRecord struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ImportResult describes the number of records of each type that were imported
+type ImportResult struct {
+	Gyms          int `json:"gyms"`
+	Classes       int `json:"classes"`
+	Users         int `json:"users"`
+	Attendance    int `json:"attendance"`
+	SavedSearches int `json:"savedSearches"`
+	Profiles      int `json:"profiles"`
+	Skipped       int `json:"skipped"`
+}
+
+// The CSV files written by ExportCSV, one per type of record
+var csvFiles = map[string]string{
+	RecordGym:         "gyms.csv",
+	RecordClass:       "classes.csv",
+	RecordUser:        "users.csv",
+	RecordAttendance:  "attendance.csv",
+	RecordSavedSearch: "saved_searches.csv",
+	RecordProfile:     "profiles.csv",
+}
+
+var csvHeaders = map[string][]string{
+	RecordGym:         {"name", "id"},
+	RecordClass:       {"uuid", "gym", "name", "location", "start_datetime", "end_datetime", "insert_datetime", "instructor"},
+	RecordUser:        {"id", "full_name", "first_name", "last_name", "nickname", "gender", "email", "verified", "locale", "last_updated"},
+	RecordAttendance:  {"id", "user_id", "class_uuid", "status", "timestamp", "history", "rating", "rpe", "notes", "metrics"},
+	RecordSavedSearch: {"id", "user_id", "name", "text", "query", "within", "created", "updated"},
+	RecordProfile: {"user_id", "home_gym", "other_gyms", "timezone", "preferred_classes", "preferred_days", "preferred_from", "preferred_to",
+		"blocked_classes", "updated"},
+}
+
+// The order that records are exported and imported in, classes must exist before attendance references them
+var recordOrder = []string{RecordGym, RecordClass, RecordUser, RecordAttendance, RecordSavedSearch, RecordProfile}
+
+// exportData holds every record that is exported from the database
+type exportData struct {
+	gyms          []Gym
+	classes       []GymClass
+	users         []User
+	attendance    []Attendance
+	savedSearches []SavedSearch
+	profiles      []UserProfile
+}
+
+// ExportJSONLines writes every gym, class, user, attendance record, saved search and profile in the database to w, one JSON object per line
+func ExportJSONLines(w io.Writer, dbConfig *Config) error {
+	data, err := readExportData(dbConfig)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	err = data.each(func(recordType string, v interface{}) error {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(ExportRecord{Type: recordType, Data: raw})
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to write JSON Lines export")
+		return err
+	}
+	log.Infof("Exported %d classes, %d users and %d attendance records", len(data.classes), len(data.users), len(data.attendance))
+	return nil
+}
+
+// ImportJSONLines reads records written by ExportJSONLines and stores them in the database
+// Records are saved by their ID so importing the same file more than once has no further effect
+func ImportJSONLines(r io.Reader, dbConfig *Config) (ImportResult, error) {
+	im, err := newImporter(dbConfig)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer im.tx.Rollback()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record ExportRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("Failed to parse record on line %d: %s", line, err)
+		}
+		v, err := newRecord(record.Type)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("Failed to import line %d: %s", line, err)
+		}
+		err = json.Unmarshal(record.Data, v)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("Failed to parse %s on line %d: %s", record.Type, line, err)
+		}
+		err = im.save(v)
+		if err != nil {
+			return ImportResult{}, err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to read JSON Lines import")
+		return ImportResult{}, err
+	}
+	return im.commit()
+}
+
+// ExportCSV writes every gym, class, user, attendance record, saved search and profile in the database to a CSV file per type of record in dir
+func ExportCSV(dir string, dbConfig *Config) error {
+	data, err := readExportData(dbConfig)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "dir": dir}).Error("Failed to create export directory")
+		return err
+	}
+
+	rows := map[string][][]string{}
+	data.each(func(recordType string, v interface{}) error {
+		rows[recordType] = append(rows[recordType], formatCSVRecord(v))
+		return nil
+	})
+
+	for _, recordType := range recordOrder {
+		err = writeCSVFile(filepath.Join(dir, csvFiles[recordType]), csvHeaders[recordType], rows[recordType])
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "type": recordType}).Error("Failed to write CSV export")
+			return err
+		}
+	}
+	log.Infof("Exported %d classes, %d users and %d attendance records to %s", len(data.classes), len(data.users), len(data.attendance), dir)
+	return nil
+}
+
+// ImportCSV reads the CSV files written by ExportCSV from dir and stores them in the database
+// Missing files are skipped and records are saved by their ID so importing more than once has no further effect
+func ImportCSV(dir string, dbConfig *Config) (ImportResult, error) {
+	im, err := newImporter(dbConfig)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer im.tx.Rollback()
+
+	for _, recordType := range recordOrder {
+		path := filepath.Join(dir, csvFiles[recordType])
+		rows, err := readCSVFile(path, csvHeaders[recordType])
+		if os.IsNotExist(err) {
+			log.WithFields(log.Fields{"path": path}).Info("No CSV file to import")
+			continue
+		} else if err != nil {
+			log.WithFields(log.Fields{"error": err, "path": path}).Error("Failed to read CSV import")
+			return ImportResult{}, err
+		}
+		for i, row := range rows {
+			v, err := parseCSVRecord(recordType, row)
+			if err != nil {
+				return ImportResult{}, fmt.Errorf("Failed to parse %s on row %d: %s", path, i+2, err)
+			}
+			err = im.save(v)
+			if err != nil {
+				return ImportResult{}, err
+			}
+		}
+	}
+	return im.commit()
+}
+
+// each calls fn for every record in the order they should be imported
+func (d exportData) each(fn func(recordType string, v interface{}) error) error {
+	for _, g := range d.gyms {
+		if err := fn(RecordGym, g); err != nil {
+			return err
+		}
+	}
+	for _, c := range d.classes {
+		if err := fn(RecordClass, c); err != nil {
+			return err
+		}
+	}
+	for _, u := range d.users {
+		if err := fn(RecordUser, u); err != nil {
+			return err
+		}
+	}
+	for _, a := range d.attendance {
+		if err := fn(RecordAttendance, a); err != nil {
+			return err
+		}
+	}
+	for _, s := range d.savedSearches {
+		if err := fn(RecordSavedSearch, s); err != nil {
+			return err
+		}
+	}
+	for _, p := range d.profiles {
+		if err := fn(RecordProfile, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readExportData(dbConfig *Config) (exportData, error) {
+	var data exportData
+	data.gyms = Gyms
+	// Read everything within one transaction so the export is a consistent snapshot
+	tx, err := dbConfig.DB.Begin(false)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction for export")
+		return exportData{}, err
+	}
+	defer tx.Rollback()
+	err = tx.All(&data.classes)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get classes to export")
+		return exportData{}, err
+	}
+	err = tx.All(&data.users)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get users to export")
+		return exportData{}, err
+	}
+	err = tx.All(&data.attendance)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get attendance to export")
+		return exportData{}, err
+	}
+	err = tx.All(&data.savedSearches)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get saved searches to export")
+		return exportData{}, err
+	}
+	err = tx.All(&data.profiles)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get profiles to export")
+		return exportData{}, err
+	}
+	return data, nil
+}
+
+// importer saves imported records within a single transaction
+type importer struct {
+	tx     storm.Node
+	result ImportResult
+}
+
+func newImporter(dbConfig *Config) (*importer, error) {
+	tx, err := dbConfig.DB.Begin(true)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction for import")
+		return nil, err
+	}
+	return &importer{tx: tx}, nil
+}
+
+func newRecord(recordType string) (interface{}, error) {
+	switch recordType {
+	case RecordGym:
+		return &Gym{}, nil
+	case RecordClass:
+		return &GymClass{}, nil
+	case RecordUser:
+		return &User{}, nil
+	case RecordAttendance:
+		return &Attendance{}, nil
+	case RecordSavedSearch:
+		return &SavedSearch{}, nil
+	case RecordProfile:
+		return &UserProfile{}, nil
+	}
+	return nil, fmt.Errorf("Unknown record type '%s'", recordType)
+}
+
+func (im *importer) save(v interface{}) error {
+	var err error
+	switch r := v.(type) {
+	case *Gym:
+		// Gyms aren't stored in the database so they are only checked against the known gyms
+		if GetGymByID(r.ID) != *r {
+			log.WithFields(log.Fields{"name": r.Name, "ID": r.ID}).Warn("Skipping unknown gym in import")
+			im.result.Skipped++
+			return nil
+		}
+		im.result.Gyms++
+	case *GymClass:
+		err = im.tx.Save(r)
+		im.result.Classes++
+	case *User:
+		err = im.tx.Save(r)
+		im.result.Users++
+	case *Attendance:
+		err = im.tx.Save(r)
+		im.result.Attendance++
+	case *SavedSearch:
+		err = im.tx.Save(r)
+		im.result.SavedSearches++
+	case *UserProfile:
+		err = im.tx.Save(r)
+		im.result.Profiles++
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "row": v}).Error("Failed to import record")
+	}
+	return err
+}
+
+func (im *importer) commit() (ImportResult, error) {
+	err := im.tx.Commit()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to commit import")
+		return ImportResult{}, err
+	}
+	log.Infof("Imported %d classes, %d users and %d attendance records", im.result.Classes, im.result.Users, im.result.Attendance)
+	return im.result, nil
+}
+
+func formatCSVRecord(v interface{}) []string {
+	switch r := v.(type) {
+	case Gym:
+		return []string{r.Name, r.ID}
+	case GymClass:
+		return []string{r.UUID, r.Gym, r.Name, r.Location, formatCSVTime(r.StartDateTime), formatCSVTime(r.EndDateTime), formatCSVTime(r.InsertDateTime), r.Instructor}
+	case User:
+		return []string{r.ID, r.Name, r.FirstName, r.LastName, r.NickName, r.Gender, r.Email, strconv.FormatBool(r.Verified), r.Locale, formatCSVTime(r.LastUpdated)}
+	case Attendance:
+		return []string{r.ID, r.UserID, r.ClassUUID, r.Status, formatCSVTime(r.Timestamp), formatCSVJSON(r.History),
+			formatCSVInt(r.Workout.Rating), formatCSVInt(r.Workout.RPE), r.Workout.Notes, formatCSVJSON(r.Workout.Metrics)}
+	case SavedSearch:
+		// The query is written as JSON rather than in the query language so every field is kept
+		return []string{strconv.Itoa(r.ID), r.UserID, r.Name, r.Text, formatCSVJSON(r.Query), formatQueryDuration(r.Within), formatCSVTime(r.Created), formatCSVTime(r.Updated)}
+	case UserProfile:
+		return []string{r.UserID, r.HomeGym, formatCSVJSON(r.OtherGyms), r.Timezone, formatCSVJSON(r.PreferredClasses), formatCSVJSON(r.PreferredDays),
+			formatCSVTimeOfDay(r.PreferredFrom), formatCSVTimeOfDay(r.PreferredTo), formatCSVJSON(r.BlockedClasses), formatCSVTime(r.Updated)}
+	}
+	return nil
+}
+
+func parseCSVRecord(recordType string, row []string) (interface{}, error) {
+	var p csvParser
+	switch recordType {
+	case RecordGym:
+		return &Gym{Name: row[0], ID: row[1]}, nil
+	case RecordClass:
+		return &GymClass{
+			UUID:           row[0],
+			Gym:            row[1],
+			Name:           row[2],
+			Location:       row[3],
+			StartDateTime:  p.time(row[4]),
+			EndDateTime:    p.time(row[5]),
+			InsertDateTime: p.time(row[6]),
+			Instructor:     row[7],
+		}, p.err
+	case RecordUser:
+		return &User{
+			ID:          row[0],
+			Name:        row[1],
+			FirstName:   row[2],
+			LastName:    row[3],
+			NickName:    row[4],
+			Gender:      row[5],
+			Email:       row[6],
+			Verified:    p.bool(row[7]),
+			Locale:      row[8],
+			LastUpdated: p.time(row[9]),
+		}, p.err
+	case RecordAttendance:
+		a := &Attendance{
+			ID:        row[0],
+			UserID:    row[1],
+			ClassUUID: row[2],
+			Status:    row[3],
+			Timestamp: p.time(row[4]),
+		}
+		p.json(row[5], &a.History)
+		a.Workout.Rating = p.int(row[6])
+		a.Workout.RPE = p.int(row[7])
+		a.Workout.Notes = row[8]
+		p.json(row[9], &a.Workout.Metrics)
+		return a, p.err
+	case RecordSavedSearch:
+		s := &SavedSearch{
+			ID:      p.int(row[0]),
+			UserID:  row[1],
+			Name:    row[2],
+			Text:    row[3],
+			Within:  p.duration(row[5]),
+			Created: p.time(row[6]),
+			Updated: p.time(row[7]),
+		}
+		p.json(row[4], &s.Query)
+		return s, p.err
+	case RecordProfile:
+		profile := &UserProfile{
+			UserID:        row[0],
+			HomeGym:       row[1],
+			Timezone:      row[3],
+			PreferredFrom: p.timeOfDay(row[6]),
+			PreferredTo:   p.timeOfDay(row[7]),
+			Updated:       p.time(row[9]),
+		}
+		p.json(row[2], &profile.OtherGyms)
+		p.json(row[4], &profile.PreferredClasses)
+		p.json(row[5], &profile.PreferredDays)
+		p.json(row[8], &profile.BlockedClasses)
+		return profile, p.err
+	}
+	return nil, fmt.Errorf("Unknown record type '%s'", recordType)
+}
+
+// csvParser converts CSV values keeping the first error encountered
+type csvParser struct {
+	err error
+}
+
+func (p *csvParser) time(v string) time.Time {
+	if v == "" || p.err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		p.err = err
+	}
+	return t
+}
+
+// json parses a value written by formatCSVJSON into v, leaving v unchanged when the value is empty
+func (p *csvParser) json(value string, v interface{}) {
+	if value == "" || p.err != nil {
+		return
+	}
+	p.err = json.Unmarshal([]byte(value), v)
+}
+
+// int parses a value written by formatCSVInt, where an empty value is zero
+func (p *csvParser) int(v string) int {
+	if v == "" || p.err != nil {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		p.err = err
+	}
+	return i
+}
+
+// duration parses a value written by formatQueryDuration, where an empty value is zero
+func (p *csvParser) duration(v string) time.Duration {
+	if v == "" || p.err != nil {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.err = err
+	}
+	return d
+}
+
+// timeOfDay parses a value written by formatCSVTimeOfDay, where an empty value is midnight
+func (p *csvParser) timeOfDay(v string) TimeOfDay {
+	if v == "" || p.err != nil {
+		return 0
+	}
+	t, err := parseTimeOfDay(v)
+	if err != nil {
+		p.err = err
+	}
+	return t
+}
+
+func (p *csvParser) bool(v string) bool {
+	if p.err != nil {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.err = err
+	}
+	return b
+}
+
+func writeCSVFile(path string, header []string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err = w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readCSVFile reads the rows of a CSV file after checking its header. Files written before columns were added
+// to the end of the header can still be read, and the missing columns are returned as empty values
+func readCSVFile(path string, header []string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return rows, nil
+	}
+	if len(rows[0]) > len(header) {
+		return nil, fmt.Errorf("Unexpected column '%s' in %s", rows[0][len(header)], path)
+	}
+	for i, h := range rows[0] {
+		if header[i] != h {
+			return nil, fmt.Errorf("Unexpected column '%s' in %s, expected '%s'", h, path, header[i])
+		}
+	}
+	// The reader has already checked every row has as many columns as the header
+	for i, row := range rows[1:] {
+		rows[i+1] = append(row, make([]string, len(header)-len(row))...)
+	}
+	return rows[1:], nil
+}
+
+// formatCSVJSON writes a value which doesn't fit into a single column as JSON
+func formatCSVJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return ""
+	}
+	return string(b)
+}
+
+// formatCSVInt leaves zero values empty, so they can be told apart from values which were never set
+func formatCSVInt(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+// formatCSVTimeOfDay leaves midnight empty, as a time of day of zero is usually one which was never set
+func formatCSVTimeOfDay(t TimeOfDay) string {
+	if t == 0 {
+		return ""
+	}
+	return t.String()
+}
+
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,193 @@
+package lm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testUser = User{
+	ID:          "123",
+	Name:        "Jane Smith",
+	FirstName:   "Jane",
+	LastName:    "Smith",
+	NickName:    "jane",
+	Email:       "jane@example.com",
+	Verified:    true,
+	Locale:      "en-NZ",
+	LastUpdated: time.Date(2017, 3, 1, 9, 30, 0, 0, time.UTC),
+}
+
+// storeExportData fills the database with classes, a user, their attendance, a saved search and a profile
+func storeExportData(t *testing.T, config *Config) {
+	err := clearDB(config)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	_, err = StoreClasses(testClasses, config)
+	if err != nil {
+		t.Errorf("Error when storing classes: %s", err)
+	}
+	err = StoreUser(testUser, config)
+	if err != nil {
+		t.Errorf("Error when storing user: %s", err)
+	}
+	search := SavedSearch{
+		UserID: testUser.ID,
+		Name:   "Early RPM at city",
+		Query:  GymQuery{Class: []string{"RPM"}, Gym: []Gym{GetGymByName("city")}, ToTime: NewTimeOfDay(8, 0)},
+		Within: 7 * 24 * time.Hour,
+	}
+	_, err = StoreSavedSearch(search, config)
+	if err != nil {
+		t.Errorf("Error when storing saved search: %s", err)
+	}
+	profile := UserProfile{
+		UserID:           testUser.ID,
+		HomeGym:          "city",
+		OtherGyms:        []string{"britomart"},
+		Timezone:         "Australia/Sydney",
+		PreferredClasses: []string{"RPM", "BODYPUMP"},
+		PreferredDays:    []time.Weekday{time.Saturday, time.Monday},
+		PreferredFrom:    NewTimeOfDay(6, 30),
+		PreferredTo:      NewTimeOfDay(9, 0),
+		BlockedClasses:   []string{"YOGA"},
+	}
+	err = StoreUserProfile(profile, config)
+	if err != nil {
+		t.Errorf("Error when storing profile: %s", err)
+	}
+	// The classes are stored once they have all started so they are attended
+	clock := config.Clock
+	config.Clock = func() time.Time { return now.Add(6 * time.Hour) }
+	defer func() { config.Clock = clock }()
+	for _, c := range testClasses[:3] {
+		_, err = StoreUserClass(testUser.ID, c.UUID, config)
+		if err != nil {
+			t.Errorf("Error when storing user classes: %s", err)
+		}
+	}
+}
+
+func TestExportImportJSONLines(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer testConfig.DB.Close()
+	storeExportData(t, testConfig)
+
+	var exported bytes.Buffer
+	err = ExportJSONLines(&exported, testConfig)
+	assert.NoError(t, err, "Failed to export database")
+	assert.Equal(t, len(Gyms)+len(testClasses)+1+3+1+1, strings.Count(exported.String(), "\n"), "Did not export one line per record")
+
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+
+	// Importing twice should be the same as importing once
+	for i := 0; i < 2; i++ {
+		result, err := ImportJSONLines(bytes.NewReader(exported.Bytes()), testConfig)
+		assert.NoError(t, err, "Failed to import database")
+		assert.Equal(t, ImportResult{Gyms: len(Gyms), Classes: len(testClasses), Users: 1, Attendance: 3, SavedSearches: 1, Profiles: 1}, result, "Did not import every record")
+	}
+
+	var reexported bytes.Buffer
+	err = ExportJSONLines(&reexported, testConfig)
+	assert.NoError(t, err, "Failed to export database")
+	assert.Equal(t, exported.String(), reexported.String(), "Export after import was not the same as the original")
+}
+
+func TestImportJSONLinesErrors(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer testConfig.DB.Close()
+
+	importTests := []string{
+		`{"type":"class","data":{"uuid":"123"}`,
+		`{"type":"trainer","data":{}}`,
+		`{"type":"user","data":{"sub":123}}`,
+	}
+	for _, test := range importTests {
+		_, err := ImportJSONLines(strings.NewReader(test), testConfig)
+		assert.Error(t, err, "Expected an error importing %s", test)
+	}
+}
+
+func TestExportImportCSV(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer testConfig.DB.Close()
+	storeExportData(t, testConfig)
+
+	dir, err := ioutil.TempDir("", "gymexport")
+	if err != nil {
+		t.Errorf("Failed to create export directory %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var exported bytes.Buffer
+	err = ExportJSONLines(&exported, testConfig)
+	assert.NoError(t, err, "Failed to export database")
+	err = ExportCSV(dir, testConfig)
+	assert.NoError(t, err, "Failed to export database as CSV")
+
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	result, err := ImportCSV(dir, testConfig)
+	assert.NoError(t, err, "Failed to import CSV")
+	assert.Equal(t, ImportResult{Gyms: len(Gyms), Classes: len(testClasses), Users: 1, Attendance: 3, SavedSearches: 1, Profiles: 1}, result, "Did not import every record")
+
+	var reexported bytes.Buffer
+	err = ExportJSONLines(&reexported, testConfig)
+	assert.NoError(t, err, "Failed to export database")
+	assert.Equal(t, exported.String(), reexported.String(), "CSV import was not the same as the original")
+}
+
+func TestImportCSVWithoutNewColumns(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	defer testConfig.DB.Close()
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "gymexport")
+	if err != nil {
+		t.Errorf("Failed to create export directory %s", err)
+	}
+	defer os.RemoveAll(dir)
+	// Attendance exported before its history was stored
+	old := "id,user_id,class_uuid,status,timestamp\n123/abc,123,abc,attended,2018-04-03T06:00:00Z\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "attendance.csv"), []byte(old), 0644)
+	if err != nil {
+		t.Errorf("Failed to write CSV %s", err)
+	}
+	result, err := ImportCSV(dir, testConfig)
+	assert.NoError(t, err, "Failed to import CSV without the history column")
+	assert.Equal(t, 1, result.Attendance)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "attendance.csv"), []byte("id,user_id,class_uuid,status,timestamp,history,unknown\n"), 0644)
+	if err != nil {
+		t.Errorf("Failed to write CSV %s", err)
+	}
+	_, err = ImportCSV(dir, testConfig)
+	assert.Error(t, err, "Expected an error for an unknown column")
+}
@@ -0,0 +1,120 @@
+package lm
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuloV/ics-golang"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Interval is the period of time from Start until End
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// TravelTimes describes how long it takes to get to and from each gym, keyed by gym name
+// Gyms without a travel time use Default
+type TravelTimes struct {
+	Default time.Duration            `json:"default"`
+	Gyms    map[string]time.Duration `json:"gyms"`
+}
+
+// Overlaps returns whether the intervals share any time, intervals which only touch don't overlap
+func (i Interval) Overlaps(o Interval) bool {
+	return i.Start.Before(o.End) && o.Start.Before(i.End)
+}
+
+// For returns the travel time for a gym. Gym names are matched exactly first and then ignoring case, taking the
+// first name in alphabetical order when more than one matches
+func (t TravelTimes) For(gym string) time.Duration {
+	if d, ok := t.Gyms[gym]; ok {
+		return d
+	}
+	names := make([]string, 0, len(t.Gyms))
+	for name := range t.Gyms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.EqualFold(name, gym) {
+			return t.Gyms[name]
+		}
+	}
+	return t.Default
+}
+
+// Free returns the classes that fit entirely into the time between the busy intervals, leaving time to
+// travel to the class's gym before it starts and away from the gym after it ends
+func (g GymClasses) Free(busy []Interval, travel TravelTimes) GymClasses {
+	free := make(GymClasses, 0, len(g))
+	for _, class := range g {
+		buffer := travel.For(class.Gym)
+		needed := Interval{Start: class.StartDateTime.Add(-buffer), End: class.EndDateTime.Add(buffer)}
+		clash := false
+		for _, b := range busy {
+			if needed.Overlaps(b) {
+				clash = true
+				break
+			}
+		}
+		if !clash {
+			free = append(free, class)
+		}
+	}
+	return free
+}
+
+// QueryFreeClasses returns the classes matching the query that the user can get to given the times they are busy
+// and how long it takes to travel to each gym. Any limit, offset or cursor is applied to the free classes
+func QueryFreeClasses(query GymQuery, busy []Interval, travel TravelTimes, dbConfig *Config) (GymClasses, error) {
+	all := query
+	all.Limit = 0
+	all.Offset = 0
+	all.Cursor = ""
+	classes, err := QueryClasses(all, dbConfig)
+	if err != nil {
+		return GymClasses{}, err
+	}
+	free := classes.Free(busy, travel)
+	page, err := paginate(free, query)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to page through free classes")
+		return GymClasses{}, err
+	}
+	log.Infof("Found %d of %d classes in free time", page.Total, len(classes))
+	return page.Classes, nil
+}
+
+// BusyFromICS returns the times of the events in an ICS calendar, read from a URL or file path
+// Cancelled events are ignored. The ICS parser drops the TZID of each time and reads it as UTC, so like parseICS
+// the times are moved back into the calendar's timezone from X-WR-TIMEZONE, or left in UTC without one
+func BusyFromICS(source string) ([]Interval, error) {
+	parser := ics.New()
+	inputChan := parser.GetInputChan()
+	inputChan <- source
+	parser.Wait()
+	cal, err := parser.GetCalendars()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "source": source}).Error("Failed to get busy calendar")
+		return nil, err
+	}
+	if len(cal) == 0 {
+		return nil, errors.New("Unable to read a calendar from " + source)
+	}
+	var busy []Interval
+	for _, c := range cal {
+		loc := c.GetTimezone()
+		for _, event := range c.GetEvents() {
+			if strings.EqualFold(event.GetStatus(), "CANCELLED") {
+				continue
+			}
+			busy = append(busy, Interval{Start: wallClockIn(event.GetStart(), &loc), End: wallClockIn(event.GetEnd(), &loc)})
+		}
+	}
+	sort.Slice(busy, func(i, j int) bool { return busy[i].Start.Before(busy[j].Start) })
+	return busy, nil
+}
@@ -0,0 +1,79 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFree(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2018, 4, 3, hour, minute, 0, 0, gymLocation) }
+	class := func(uuid string, gym string, start time.Time, length time.Duration) GymClass {
+		return GymClass{UUID: uuid, Gym: gym, Name: "RPM", StartDateTime: start, EndDateTime: start.Add(length)}
+	}
+	classes := GymClasses{
+		class("early", "city", at(6, 0), 45*time.Minute),
+		class("before-work", "city", at(7, 45), 30*time.Minute),
+		class("lunch", "city", at(12, 5), 45*time.Minute),
+		class("lunch-takapuna", "takapuna", at(12, 15), 30*time.Minute),
+		class("after-work", "britomart", at(17, 15), 45*time.Minute),
+		class("evening", "newmarket", at(18, 0), 45*time.Minute),
+	}
+	busy := []Interval{
+		{at(8, 30), at(12, 0)},
+		{at(13, 0), at(17, 0)},
+	}
+	var tests = []struct {
+		name     string
+		travel   TravelTimes
+		expected []string
+	}{
+		{"No travel time", TravelTimes{}, []string{"early", "before-work", "lunch", "lunch-takapuna", "after-work", "evening"}},
+		{"Default travel time", TravelTimes{Default: 15 * time.Minute}, []string{"early", "before-work", "lunch-takapuna", "after-work", "evening"}},
+		{"Travel time per gym", TravelTimes{Default: 10 * time.Minute, Gyms: map[string]time.Duration{"Takapuna": 30 * time.Minute, "britomart": 5 * time.Minute}}, []string{"early", "before-work", "after-work", "evening"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, classUUIDs(classes.Free(busy, test.travel)), test.name)
+	}
+}
+
+func TestTravelTimesFor(t *testing.T) {
+	travel := TravelTimes{Default: 10 * time.Minute, Gyms: map[string]time.Duration{"city": 5 * time.Minute, "City": 15 * time.Minute, "CITY": 20 * time.Minute, "Takapuna": 30 * time.Minute}}
+	var tests = []struct {
+		gym      string
+		expected time.Duration
+	}{
+		{"city", 5 * time.Minute},
+		{"City", 15 * time.Minute},
+		// Names which only match ignoring case always use the first in alphabetical order
+		{"cItY", 20 * time.Minute},
+		{"takapuna", 30 * time.Minute},
+		{"britomart", 10 * time.Minute},
+	}
+	for i := 0; i < 10; i++ {
+		for _, test := range tests {
+			assert.Equal(t, test.expected, travel.For(test.gym), test.gym)
+		}
+	}
+}
+
+func TestBusyFromICS(t *testing.T) {
+	busy, err := BusyFromICS("busy.ics")
+	assert.NoError(t, err)
+	// The cancelled event is ignored
+	if assert.Len(t, busy, 2) {
+		assert.True(t, time.Date(2018, 4, 2, 20, 30, 0, 0, time.UTC).Equal(busy[0].Start), "start %v", busy[0].Start)
+		assert.True(t, time.Date(2018, 4, 3, 5, 0, 0, 0, time.UTC).Equal(busy[0].End), "end %v", busy[0].End)
+	}
+}
+
+func TestBusyFromICSTimezone(t *testing.T) {
+	busy, err := BusyFromICS("busy-auckland.ics")
+	assert.NoError(t, err)
+	// Times are in the calendar's timezone rather than UTC
+	if assert.Len(t, busy, 1) {
+		assert.True(t, time.Date(2018, 4, 3, 8, 30, 0, 0, gymLocation).Equal(busy[0].Start), "start %v", busy[0].Start)
+		assert.True(t, time.Date(2018, 4, 3, 17, 0, 0, 0, gymLocation).Equal(busy[0].End), "end %v", busy[0].End)
+	}
+}
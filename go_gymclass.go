@@ -2,9 +2,7 @@ package lm
 
 import (
 	"crypto/sha256"
-	"errors"
 	"fmt"
-	"math"
 	"os"
 	"sort"
 	"strings"
@@ -13,9 +11,11 @@ import (
 	"github.com/PuloV/ics-golang"
 	log "github.com/Sirupsen/logrus"
 	"github.com/asdine/storm"
-	"github.com/jsgoecke/go-wit"
 )
 
+// gymLocation is the timezone the gyms are in, loaded from TimeZone
+var gymLocation = time.UTC
+
 // Gyms provides a mapping of all the gyms that are available
 var Gyms = []Gym{
 	Gym{"city", "96382586-e31c-df11-9eaa-0050568522bb"},
@@ -24,17 +24,63 @@ var Gyms = []Gym{
 	Gym{"newmarket", "b6aa431c-ce1a-e511-a02f-0050568522bb"},
 }
 
+// TimeZone is the timezone that the gyms are in and that their timetables use
+const TimeZone = "Pacific/Auckland"
+
+// The categories that classes are grouped into
+const (
+	CategoryCardio      = "cardio"
+	CategoryStrength    = "strength"
+	CategoryFlexibility = "flexibility"
+	CategoryCore        = "core"
+)
+
 // Classes provides a list of all the support classes
-var Classes = []string{
-	// TODO: IMPLEMENT ME
+var Classes = []ClassType{
+	ClassType{"RPM", CategoryCardio},
+	ClassType{"GRIT STRENGTH", CategoryStrength},
+	ClassType{"GRIT CARDIO", CategoryCardio},
+	ClassType{"GRIT PLYO", CategoryCardio},
+	ClassType{"BODYPUMP", CategoryStrength},
+	ClassType{"BODYBALANCE", CategoryFlexibility},
+	ClassType{"BODYATTACK", CategoryCardio},
+	ClassType{"CXWORX", CategoryCore},
+	ClassType{"SH'BAM", CategoryCardio},
+	ClassType{"BODYCOMBAT", CategoryCardio},
+	ClassType{"YOGA", CategoryFlexibility},
+	ClassType{"BODYJAM", CategoryCardio},
+	ClassType{"SPRINT", CategoryCardio},
+	ClassType{"BODYVIVE", CategoryCardio},
+	ClassType{"BODYSTEP", CategoryCardio},
+	ClassType{"BORN TO MOVE", CategoryCardio},
 }
 
 // Config is used to store DB configuration for storing data
 type Config struct {
 	DBPath string
 	DB     *storm.DB
+	NLU    NLU
+	// Clock returns the current time, it defaults to time.Now
+	Clock func() time.Time
+	// Timezone is used to work out relative dates such as "today", it defaults to the gyms' timezone
+	Timezone *time.Location
+	// TravelGap is the time needed between classes at different gyms before they are treated as clashing
+	TravelGap time.Duration
+	// WeeklyTarget is the number of classes a week a user aims for, it defaults to DefaultWeeklyTarget
+	WeeklyTarget int
+	// TargetMix is the share of classes a user aims to do in each category, it defaults to DefaultTargetMix
+	TargetMix TargetMix
 }
 
+// ClassType describes a type of class and the category it belongs to
+type ClassType struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+// TimeOfDay describes a local time of day as the number of minutes after midnight
+type TimeOfDay int
+
 // Gym provides a mapping between a gym's name and their unique ID
 type Gym struct {
 	Name string
@@ -50,6 +96,7 @@ type GymClass struct {
 	StartDateTime  time.Time `json:"startdatetime" db:"start_datetime" storm:"index"`
 	EndDateTime    time.Time `json:"enddatetime" db:"end_datetime" storm:"index"`
 	InsertDateTime time.Time `json:"insertdatetime" db:"insert_datetime" storm:"index"`
+	Instructor     string    `json:"instructor" db:"instructor" storm:"index"`
 }
 
 // User desribes a person using a gym
@@ -60,18 +107,58 @@ type User struct {
 	LastName    string    `json:"family_name" db:"last_name"`
 	NickName    string    `json:"nickname" db:"nickname"`
 	Gender      string    `json:"gender" db:"gender"`
-	Email       string    `json:"email" db:"email"`
+	Email       string    `json:"email" db:"email" storm:"index"`
 	Verified    bool      `json:"verified" db:"verified"`
 	Locale      string    `json:"locale" db:"locale"`
 	LastUpdated time.Time `json:"updated_at" db:"last_updated"`
 }
 
+// StoreResult describes the outcome of storing a collection of GymClasses
+type StoreResult struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
 // UserGymClass describes a user and all their associated classes
+//
+// Deprecated: classes a user has been to are stored as Attendance records, UserGymClass is only kept so
+// that existing databases can be migrated with MigrateUserGymClasses
 type UserGymClass struct {
 	UserID  string     `storm:"id"`
 	Classes GymClasses `storm:"index"`
 }
 
+// The statuses of a user's Attendance at a class
+const (
+	AttendancePlanned         = "planned"
+	AttendanceAttended        = "attended"
+	AttendanceNoShow          = "no-show"
+	AttendanceCancelledByGym  = "cancelled-by-gym"
+	AttendanceCancelledByUser = "cancelled-by-user"
+)
+
+// Attendance describes a user going to a particular class. The class is referenced by its UUID so that
+// any changes to the class are reflected in the user's history
+// Timestamp is when the status was last set and History records every change of status
+// Workout holds how an attended class went, and is empty until the user rates it
+type Attendance struct {
+	ID        string         `json:"id" db:"id" storm:"id"`
+	UserID    string         `json:"userID" db:"user_id" storm:"index"`
+	ClassUUID string         `json:"classUUID" db:"class_uuid" storm:"index"`
+	Status    string         `json:"status" db:"status" storm:"index"`
+	Timestamp time.Time      `json:"timestamp" db:"timestamp" storm:"index"`
+	History   []StatusChange `json:"history" db:"history"`
+	Workout   Workout        `json:"workout" db:"workout"`
+}
+
+// StatusChange describes an Attendance moving from one status to another, From is empty when it was created
+type StatusChange struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 // GymPreference describes a preference to go to a particular Gym. The preference should be a value between 0 - 1
 type GymPreference struct {
 	Gym        Gym     `json:"gym"`
@@ -84,22 +171,51 @@ type ClassPreference struct {
 	Preference float64 `json:"preference"`
 }
 
-// WorkOutFrequency describes the number of times a user went to any gym class on a particular week
+// WorkOutFrequency describes the number of times a user went to any gym class on a particular day, week or month
+// Start is the beginning of the period, and Year and Week are the ISO year and week for weekly counts
 type WorkOutFrequency struct {
-	Week  int `json:"week"`
-	Count int `json:"count"`
+	Year  int        `json:"year"`
+	Month time.Month `json:"month,omitempty"`
+	Week  int        `json:"week,omitempty"`
+	Day   int        `json:"day,omitempty"`
+	Start time.Time  `json:"start"`
+	Count int        `json:"count"`
 }
 
 //ClassFrequency describes the number of times a user went to a particular class on a particular week
 
 // UserStatistics describes the different statistics about a user
+// Only attended classes are counted in the totals, and NoShowRate is the share of classes that were either
+// attended or missed which the user missed. Ratings are averaged over the attended classes the user rated
+// Frequencies, streaks and the heatmap use the timezone from the user's profile, or else the configured timezone
+// Balance covers the last DefaultBalanceWeeks weeks
 type UserStatistics struct {
-	TotalClasses     int                `json:"totalClasses"`
-	ClassesPerWeek   float64            `json:"classesPerWeek"`
-	LastClassDate    time.Time          `json:"lastClassDate"`
-	GymPreferences   []GymPreference    `json:"gymPreferences"`
-	ClassPreferences []ClassPreference  `json:"classPreferences"`
-	WorkOutFrequency []WorkOutFrequency `json:"workOutFrequency"`
+	TotalClasses      int                `json:"totalClasses"`
+	PlannedClasses    int                `json:"plannedClasses"`
+	CancelledClasses  int                `json:"cancelledClasses"`
+	NoShows           int                `json:"noShows"`
+	NoShowRate        float64            `json:"noShowRate"`
+	ClassesPerWeek    float64            `json:"classesPerWeek"`
+	LastClassDate     time.Time          `json:"lastClassDate"`
+	GymPreferences    []GymPreference    `json:"gymPreferences"`
+	ClassPreferences  []ClassPreference  `json:"classPreferences"`
+	WorkOutFrequency  []WorkOutFrequency `json:"workOutFrequency"`
+	MonthlyFrequency  []WorkOutFrequency `json:"monthlyFrequency"`
+	DailyFrequency    []WorkOutFrequency `json:"dailyFrequency"`
+	Consistency       Consistency        `json:"consistency"`
+	Rates             []Rate             `json:"rates"`
+	Trend             string             `json:"trend"`
+	Heatmap           Heatmap            `json:"heatmap"`
+	DayDistribution   []Ranked           `json:"dayDistribution"`
+	HourDistribution  []Ranked           `json:"hourDistribution"`
+	ClassBreakdowns   []ClassBreakdown   `json:"classBreakdowns"`
+	Categories        []Ranked           `json:"categories"`
+	Balance           TrainingBalance    `json:"balance"`
+	AverageRating     float64            `json:"averageRating"`
+	RatedClasses      int                `json:"ratedClasses"`
+	ClassRatings      []RatingAverage    `json:"classRatings"`
+	InstructorRatings []RatingAverage    `json:"instructorRatings"`
+	GymRatings        []RatingAverage    `json:"gymRatings"`
 }
 
 // UserPreference describes a users preferences when going to the gym
@@ -112,11 +228,31 @@ type UserPreference struct {
 }
 
 // GymQuery describes a query for GymClasses
+// Times of day and days of the week are compared in Timezone, which defaults to the gym's local time. A window
+// where FromTime is later than ToTime wraps around midnight and a zero ToTime means the end of the day
+// Results are sorted by SortBy, which defaults to SortStartTime, and sorting by SortDistance requires Near.
+// Pages of results are returned using Limit and either Offset or the NextCursor of a previous ClassPage
 type GymQuery struct {
-	Gym    []Gym
-	Class  []string
-	Before time.Time
-	After  time.Time
+	Gym          []Gym
+	Class        []string
+	Before       time.Time
+	After        time.Time
+	Day          []time.Weekday
+	FromTime     TimeOfDay
+	ToTime       TimeOfDay
+	Location     []string
+	MinDuration  time.Duration
+	MaxDuration  time.Duration
+	Category     []string
+	ExcludeClass []string
+	ExcludeGym   []Gym
+	SortBy       string
+	Descending   bool
+	Near         Coordinates
+	Limit        int
+	Offset       int
+	Cursor       string
+	Timezone     *time.Location `json:"-"`
 }
 
 // ByStartDateTime implements sort.Interface for GymClasses based on the StartDateTime
@@ -127,21 +263,68 @@ type GymClasses []GymClass
 
 // NewConfig returns a new configuration with defaults
 func NewConfig() (*Config, error) {
+	return OpenConfig("gym.db")
+}
+
+// OpenConfig returns a new configuration using the database at the path provided, migrating it if it was
+// created by an older version
+func OpenConfig(path string) (*Config, error) {
 	c := &Config{}
-	c.DBPath = "gym.db"
+	c.DBPath = path
 	dbb, err := storm.Open(c.DBPath)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("Failed to open database")
 		return c, err
 	}
 	c.DB = dbb
+	err = Migrate(c)
+	if err != nil {
+		return c, err
+	}
 	return c, nil
 }
 
 // InQuery checks to see if the class is within the criteria of the GymQuery
 // Returns true if it meets the critieria otherwise returns false
 func (g GymClass) InQuery(q GymQuery) bool {
-	return compareClassName(&q, &g) && compareClassGym(&q, &g) && compareClassAfterTime(&q, &g) && compareClassBeforeTime(&q, &g)
+	return compareClassName(&q, &g) && compareClassGym(&q, &g) && compareClassAfterTime(&q, &g) && compareClassBeforeTime(&q, &g) &&
+		compareClassDay(&q, &g) && compareClassTimeOfDay(&q, &g) && compareClassLocation(&q, &g) && compareClassDuration(&q, &g) &&
+		compareClassCategory(&q, &g) && compareClassExclusions(&q, &g)
+}
+
+// Duration returns how long the class runs for
+func (g GymClass) Duration() time.Duration {
+	return g.EndDateTime.Sub(g.StartDateTime)
+}
+
+// Started returns whether the class has started by now
+func (g GymClass) Started(now time.Time) bool {
+	return !g.StartDateTime.After(now)
+}
+
+// Category returns the category of the class or an empty string if it isn't a known class
+func (g GymClass) Category() string {
+	return GetClassByName(g.Name).Category
+}
+
+// NewTimeOfDay returns the TimeOfDay for an hour and minute
+func NewTimeOfDay(hour int, minute int) TimeOfDay {
+	return TimeOfDay(hour*60 + minute)
+}
+
+// Hour returns the hour of the day
+func (t TimeOfDay) Hour() int {
+	return int(t) / 60
+}
+
+// Minute returns the minute within the hour
+func (t TimeOfDay) Minute() int {
+	return int(t) % 60
+}
+
+// String returns the time of day formatted as 15:04
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
 }
 
 // Delete will remove a GymClass from the GymClasses slice by UUID
@@ -156,10 +339,10 @@ func (g *GymClasses) Delete(classID string) bool {
 	return false
 }
 
-// Exists checks to see if a GymClass is contained within the GymClasses slice
+// Exists checks to see if a GymClass is contained within the GymClasses slice by UUID
 func (g GymClasses) Exists(c GymClass) bool {
 	for _, v := range g {
-		if c == v {
+		if c.UUID == v.UUID {
 			return true
 		}
 	}
@@ -171,17 +354,15 @@ func (g GymClasses) Total() int {
 	return len(g)
 }
 
-// OldestClass returns the oldest class date in the slice
+// OldestClass returns the oldest class in the slice, including classes in the future
 func (g GymClasses) OldestClass() GymClass {
-	var oldest = time.Now()
-	var lc GymClass
-	for _, c := range g {
-		if c.StartDateTime.Before(oldest) {
-			oldest = c.StartDateTime
-			lc = c
+	var oc GymClass
+	for i, c := range g {
+		if i == 0 || c.StartDateTime.Before(oc.StartDateTime) {
+			oc = c
 		}
 	}
-	return lc
+	return oc
 }
 
 // LatestClass returns the latest class date in the slice
@@ -197,151 +378,92 @@ func (g GymClasses) LatestClass() GymClass {
 	return lc
 }
 
-// PerWeek returns the number of classes per week in the slice
+// PerWeek returns the average number of classes per week from the oldest class to the latest. Classes
+// less than a week apart are treated as being in a single week
 func (g GymClasses) PerWeek() float64 {
-	lc := g.LatestClass()
-	oc := g.OldestClass()
-	t := float64(g.Total())
-
-	duration := lc.StartDateTime.Sub(oc.StartDateTime)
-	d := (duration.Hours()) / 24.0
-	if math.IsNaN(t/d) || math.IsInf(t/d, 0) {
+	if len(g) == 0 {
 		return 0.0
 	}
-	return t / d
-
+	return perWeek(g.Total(), g.LatestClass().StartDateTime.Sub(g.OldestClass().StartDateTime))
 }
 
-// ClassPreferences breaks down the classes by their percentage of all classes
+// ClassPreferences breaks down the classes by their percentage of all classes, ranked in the same order as
+// RankClasses
 func (g GymClasses) ClassPreferences() []ClassPreference {
-	cp := make(map[string]float64)
-	t := float64(g.Total())
-	for _, class := range g {
-		cp[class.Name]++
-	}
 	var c []ClassPreference
-	for k, v := range cp {
-		var x ClassPreference
-		x.Class = k
-		x.Preference = v / t
-		c = append(c, x)
+	for _, r := range g.RankClasses(0) {
+		c = append(c, ClassPreference{Class: r.Key, Preference: r.Share})
 	}
-
 	return c
 }
 
-// GymPreferences breaks down the classes by their percentage of all classes
+// GymPreferences breaks down the classes by their percentage of all classes, ranked in the same order as
+// RankGyms. Classes without a gym are left out, so the preferences are shares of the classes with a gym
 func (g GymClasses) GymPreferences() []GymPreference {
-	cp := make(map[string]float64)
-	t := float64(g.Total())
-	for _, class := range g {
-		cp[class.Gym]++
-	}
 	var c []GymPreference
-	for k, v := range cp {
-		var x GymPreference
-		x.Gym = GetGymByName(k)
-		x.Preference = v / t
-		c = append(c, x)
+	for _, r := range g.RankGyms(0) {
+		c = append(c, GymPreference{Gym: GetGymByName(r.Key), Preference: r.Share})
 	}
 	return c
 }
 
-// WeeklyCount returns a slice of WorkOutFrequency that shows the number of workouts per week in the collection of GymClasses
+// WeeklyCount returns a slice of WorkOutFrequency that shows the number of workouts in each ISO week in the
+// gyms' timezone, from the week of the first class to the week of the last
 func (g GymClasses) WeeklyCount() []WorkOutFrequency {
-	w := make(map[int]int)
-	for _, class := range g {
-		_, wk := class.StartDateTime.ISOWeek()
-		w[wk]++
-	}
-	var c []WorkOutFrequency
-	for k, v := range w {
-		var x WorkOutFrequency
-		x.Week = k
-		x.Count = v
-		c = append(c, x)
-	}
-	return c
+	return g.Frequency(FrequencyWeekly, gymLocation)
 }
 
-// MostFrequentedDay returns the weekday which contains the most number of classes
+// MostFrequentedDay returns the weekday which contains the most number of classes, using the timezone each
+// class is stored in. Ties go to the day with the most recent class
 func (g GymClasses) MostFrequentedDay() int {
-	// Map of weekday to count
-	w := make(map[int]int)
-	for _, class := range g {
-		wk := int(class.StartDateTime.Weekday())
-		w[wk]++
-	}
-	max := 0
-	day := 0
-	for k, v := range w {
-		if v > max {
-			max = v
-			day = k
-		}
-	}
-	return day
+	return g.mostFrequent(func(c GymClass) int { return int(c.StartDateTime.Weekday()) })
 }
 
-// MostFrequentedClass returns the class type which has the most number of visits
+// MostFrequentedClass returns the class type which has the most number of visits, ties go to the class
+// type with the most recent visit
 func (g GymClasses) MostFrequentedClass() string {
-	// Map of class to count
-	m := make(map[string]int)
-	for _, class := range g {
-		m[class.Name]++
-	}
-	max := 0
-	class := ""
-	for k, v := range m {
-		if v > max {
-			max = v
-			class = k
-		}
-	}
-	return class
+	return topKey(g.RankClasses(1))
 }
 
-// MostFrequentedGym returns the gym which has the most number of visits
+// MostFrequentedGym returns the gym which has the most number of visits, ties go to the gym with the most
+// recent visit
 func (g GymClasses) MostFrequentedGym() string {
-	// Map of class to count
-	m := make(map[string]int)
-	for _, class := range g {
-		m[class.Gym]++
-	}
-	max := 0
-	gym := ""
-	for k, v := range m {
-		if v > max {
-			max = v
-			gym = k
-		}
-	}
-	return gym
+	return topKey(g.RankGyms(1))
 }
 
-// MostFrequentedTime returns the hour which has the most number of visits
+// MostFrequentedTime returns the hour which has the most number of visits, using the timezone each class is
+// stored in. Ties go to the hour with the most recent visit
 func (g GymClasses) MostFrequentedTime() int {
-	// Map of class to count
-	m := make(map[int]int)
-	for _, class := range g {
-		h := class.StartDateTime.Hour()
-		m[h]++
-	}
-	max := 0
-	hour := 0
-	for k, v := range m {
-		if v > max {
-			max = v
-			hour = k
-		}
+	return g.mostFrequent(func(c GymClass) int { return c.StartDateTime.Hour() })
+}
+
+// topKey returns the key of the first ranked value, or an empty string when nothing was ranked
+func topKey(ranked []Ranked) string {
+	if len(ranked) == 0 {
+		return ""
 	}
-	return hour
+	return ranked[0].Key
 }
 
 func (a ByStartDateTime) Len() int           { return len(a) }
 func (a ByStartDateTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByStartDateTime) Less(i, j int) bool { return a[i].StartDateTime.Before(a[j].StartDateTime) }
 
+// parseInstructor returns the instructor from a class summary such as "RPM Sun 8:20am (Jane)"
+// LesMills uses "Staff" when the instructor hasn't been decided, which is treated as no instructor
+func parseInstructor(summary string) string {
+	start := strings.LastIndex(summary, "(")
+	end := strings.LastIndex(summary, ")")
+	if start == -1 || end < start {
+		return ""
+	}
+	instructor := strings.TrimSpace(summary[start+1 : end])
+	if strings.EqualFold(instructor, "staff") {
+		return ""
+	}
+	return instructor
+}
+
 func translateName(className *string) {
 	switch {
 	case strings.Contains(strings.ToUpper(*className), "RPM"):
@@ -379,11 +501,17 @@ func translateName(className *string) {
 	}
 }
 
+// wallClockIn returns the time with the same date and clock time as t in loc. The ICS parser reads every time
+// as UTC, ignoring its timezone, so this puts the times back into the timezone they were written in
+func wallClockIn(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
 func parseICS(cal *ics.Calendar, gym Gym) (GymClasses, error) {
 	log.Infof("Parsing ICS file for %s", gym.Name)
 	var foundClasses GymClasses
 	var foundClass GymClass
-	loc, err := time.LoadLocation("Pacific/Auckland")
+	loc, err := time.LoadLocation(TimeZone)
 	if err != nil {
 		log.WithFields(log.Fields{"value": err}).Error("Failed to get timezone")
 		return GymClasses{}, err
@@ -391,9 +519,10 @@ func parseICS(cal *ics.Calendar, gym Gym) (GymClasses, error) {
 	for _, event := range cal.GetEvents() {
 		start := event.GetStart()
 		end := event.GetEnd()
-		startDateTime := time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
-		endDateTime := time.Date(end.Year(), end.Month(), end.Day(), end.Hour(), end.Minute(), end.Second(), 0, loc)
+		startDateTime := wallClockIn(start, loc)
+		endDateTime := wallClockIn(end, loc)
 		name := event.GetSummary()
+		instructor := parseInstructor(name)
 		translateName(&name)
 		id := fmt.Sprintf("%s%s%s%s", gym.Name, name, event.GetLocation(), startDateTime)
 		u := sha256.Sum256([]byte(id))
@@ -404,6 +533,7 @@ func parseICS(cal *ics.Calendar, gym Gym) (GymClasses, error) {
 			Location:      event.GetLocation(),
 			StartDateTime: startDateTime,
 			EndDateTime:   endDateTime,
+			Instructor:    instructor,
 		}
 		foundClasses = append(foundClasses, foundClass)
 	}
@@ -442,41 +572,117 @@ func GetClasses(gyms []Gym) (GymClasses, error) {
 }
 
 // StoreClasses will store a list of classes into a database based on the configuration provided
-func StoreClasses(classes GymClasses, dbConfig *Config) error {
-	stdClasses := 0
+// All classes are stored in a single transaction so either every class is stored or none are
+func StoreClasses(classes GymClasses, dbConfig *Config) (StoreResult, error) {
+	var result StoreResult
+	tx, err := dbConfig.DB.Begin(true)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction to store classes")
+		return StoreResult{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
 	for _, class := range classes {
-		err := dbConfig.DB.Save(&class)
+		var existing GymClass
+		err = tx.One("UUID", class.UUID, &existing)
+		switch {
+		case err == storm.ErrNotFound:
+			class.InsertDateTime = now
+			result.Inserted++
+		case err != nil:
+			log.WithFields(log.Fields{"error": err, "row": class}).Error("Failed to find existing class in db")
+			return StoreResult{}, err
+		case sameClass(existing, class):
+			result.Unchanged++
+			continue
+		default:
+			class.InsertDateTime = existing.InsertDateTime
+			result.Updated++
+		}
+		err = tx.Save(&class)
 		if err != nil {
 			log.WithFields(log.Fields{"error": err, "row": class}).Error("Failed to insert class into db")
-			return err
+			return StoreResult{}, err
 		}
-		stdClasses++
 	}
-	log.Infof("Stored %d classes", stdClasses)
-	return nil
+
+	err = tx.Commit()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to commit classes to db")
+		return StoreResult{}, err
+	}
+	log.Infof("Stored %d classes (%d inserted, %d updated, %d unchanged)", len(classes), result.Inserted, result.Updated, result.Unchanged)
+	return result, nil
 }
 
 // QueryUserStatistics will return a list of statistics about a user based on their usage
 func QueryUserStatistics(user string, dbConfig *Config) (UserStatistics, error) {
 	var us UserStatistics
-	c, err := QueryUserClasses(user, dbConfig)
+	attendance, err := queryAttendance(user, dbConfig)
+	if err != nil {
+		return UserStatistics{}, err
+	}
+	for _, a := range attendance {
+		switch a.Status {
+		case AttendancePlanned:
+			us.PlannedClasses++
+		case AttendanceNoShow:
+			us.NoShows++
+		case AttendanceCancelledByGym, AttendanceCancelledByUser:
+			us.CancelledClasses++
+		}
+	}
+	c, err := QueryUserClassesByStatus(user, dbConfig, AttendanceAttended)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to get classes for user statistics")
 		return UserStatistics{}, err
 	}
+	if c.Total()+us.NoShows > 0 {
+		us.NoShowRate = float64(us.NoShows) / float64(c.Total()+us.NoShows)
+	}
 	us.ClassPreferences = c.ClassPreferences()
 	us.ClassesPerWeek = c.PerWeek()
 	us.TotalClasses = c.Total()
 	us.LastClassDate = c.LatestClass().StartDateTime
 	us.GymPreferences = c.GymPreferences()
-	us.WorkOutFrequency = c.WeeklyCount()
+
+	// Days, weeks and hours are in the user's timezone
+	profile, err := GetUserProfile(user, dbConfig)
+	if err != nil {
+		return UserStatistics{}, err
+	}
+	now := dbConfig.now()
+	if loc := profile.Location(); loc != nil {
+		now = now.In(loc)
+	}
+	loc := now.Location()
+	us.WorkOutFrequency = c.Frequency(FrequencyWeekly, loc)
+	us.MonthlyFrequency = c.Frequency(FrequencyMonthly, loc)
+	us.DailyFrequency = c.Frequency(FrequencyDaily, loc)
+	us.Consistency = c.Consistency(now, dbConfig.weeklyTarget())
+	us.Rates = c.Rates(now, RateWindows...)
+	us.Trend = us.Rates[0].Trend
+	us.Heatmap = c.Heatmap(loc)
+	us.DayDistribution = c.RankDays(loc, 0)
+	us.HourDistribution = c.RankHours(loc, 0)
+	us.ClassBreakdowns = c.ClassBreakdowns(loc)
+	us.Categories = c.RankCategories(0)
+	us.Balance = c.Balance(Interval{Start: now.AddDate(0, 0, -7*DefaultBalanceWeeks), End: now}, dbConfig.targetMix())
+	us.AverageRating, us.RatedClasses = averageRating(attendance)
+	us.ClassRatings, us.InstructorRatings, us.GymRatings = averageRatings(attendance, c)
 
 	return us, nil
 }
 
 // StoreUser saves a user to the database
+// The user must have an ID, and any email address must be valid and not used by another user
 func StoreUser(user User, dbConfig *Config) error {
-	err := dbConfig.DB.Save(&user)
+	err := validateUser(&user, dbConfig)
+	if err != nil {
+		return err
+	}
+	err = dbConfig.DB.Save(&user)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err, "row": user}).Error("Failed to insert user into db")
 		return err
@@ -496,19 +702,43 @@ func QueryUsers(dbConfig *Config) ([]User, error) {
 	return users, nil
 }
 
-// QueryUserClasses will return a list of classes that a particular user has saved
+// QueryUserClasses will return a list of classes that a particular user has saved, whatever their status
 func QueryUserClasses(user string, dbConfig *Config) (GymClasses, error) {
+	return QueryUserClassesByStatus(user, dbConfig)
+}
 
-	var u UserGymClass
-	err := dbConfig.DB.One("UserID", user, &u)
-	if err == storm.ErrNotFound {
-		return GymClasses{}, nil
-	} else if err != nil {
-		log.WithFields(log.Fields{"error": err}).Error("Failed to get user classes")
+// QueryUserClassesByStatus will return the classes a user has saved with any of the statuses provided, or
+// every class when no statuses are provided
+func QueryUserClassesByStatus(user string, dbConfig *Config, statuses ...string) (GymClasses, error) {
+	attendance, err := queryAttendance(user, dbConfig)
+	if err != nil {
+		return GymClasses{}, err
+	}
+
+	// Look up every class within one read transaction rather than starting one for each class
+	tx, err := dbConfig.DB.Begin(false)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to start transaction for user classes")
 		return GymClasses{}, err
 	}
+	defer tx.Rollback()
 
-	allClasses := u.Classes
+	allClasses := make(GymClasses, 0, len(attendance))
+	for _, a := range attendance {
+		if len(statuses) > 0 && !containsFold(statuses, a.Status) {
+			continue
+		}
+		var c GymClass
+		err = tx.One("UUID", a.ClassUUID, &c)
+		if err == storm.ErrNotFound {
+			log.WithFields(log.Fields{"class": a.ClassUUID, "user": user}).Warn("Unable to find class attended by user")
+			continue
+		} else if err != nil {
+			log.WithFields(log.Fields{"error": err, "class": a.ClassUUID}).Error("Failed to get class attended by user")
+			return GymClasses{}, err
+		}
+		allClasses = append(allClasses, c)
+	}
 	log.Infof("Returning %d gym classes", len(allClasses))
 	sort.Sort(ByStartDateTime(allClasses))
 
@@ -518,7 +748,7 @@ func QueryUserClasses(user string, dbConfig *Config) (GymClasses, error) {
 // QueryUserPreferences will return a users gym going preferences
 func QueryUserPreferences(user string, dbConfig *Config) (UserPreference, error) {
 	var preference UserPreference
-	c, err := QueryUserClasses(user, dbConfig)
+	c, err := QueryUserClassesByStatus(user, dbConfig, AttendanceAttended)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("Failed to get user classes when building preferences")
 		return UserPreference{}, err
@@ -531,10 +761,27 @@ func QueryUserPreferences(user string, dbConfig *Config) (UserPreference, error)
 	return preference, nil
 }
 
-// QueryPreferredClasses returns a list of classes based on a users preference
+// QueryPreferredClasses returns a list of classes for the rest of today based on a users preference
+// The preferences learned from the user's history are merged with any they have set in their UserProfile, and
+// today, the preferred days and the preferred time are in the profile's timezone or else the configuration's
+// timezone
 func QueryPreferredClasses(preference UserPreference, dbConfig *Config) (GymClasses, error) {
+	profile, err := GetUserProfile(preference.User, dbConfig)
+	if err != nil {
+		return GymClasses{}, err
+	}
+	p := mergePreferences(profile, preference)
+	if len(p.classes) == 0 && len(p.gyms) == 0 && p.from == 0 && p.to == 0 {
+		log.WithFields(log.Fields{"user": preference.User}).Info("No preferences to find classes with")
+		return GymClasses{}, nil
+	}
+
 	// Today
-	year, month, day := time.Now().UTC().Date()
+	now := dbConfig.now()
+	if loc := profile.Location(); loc != nil {
+		now = now.In(loc)
+	}
+	tomorrow := startOfDay(now).AddDate(0, 0, 1)
 	/*
 	   	 | Class | Gym | Time |
 	   	 |   0   |  0  |  1   | - Any class, any gym at a preferred time
@@ -545,35 +792,35 @@ func QueryPreferredClasses(preference UserPreference, dbConfig *Config) (GymClas
 	   	 |   1   |  1  |  0   | - Preferred class at preferred gym at any time (2)
 	   	 |   1   |  1  |  1   | - Preferred class at preferred gym at preferred time (1)
 	*/
+	var queries []GymQuery
 
 	// Preferred class at preferred gym at any time
-	var preferredQuery1 = GymQuery{}
-	preferredQuery1.Class = []string{preference.PreferredClass}
-	preferredQuery1.After = time.Now().UTC()
-	preferredQuery1.Before = time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
-	preferredQuery1.Gym = []Gym{GetGymByName(preference.PreferredGym)}
-	queryClasses1, err := QueryClasses(preferredQuery1, dbConfig)
-
-	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Error("Failed to query for preferred classes for a user")
+	if len(p.gyms) > 0 {
+		queries = append(queries, GymQuery{Class: p.classes, Gym: p.gyms})
+	}
+	// Preferred class at any gym at preferred time
+	if p.from != 0 || p.to != 0 {
+		queries = append(queries, GymQuery{Class: p.classes, FromTime: p.from, ToTime: p.to})
+	}
+	// Preferred class at any gym at any time, for users who have only chosen classes
+	if len(queries) == 0 {
+		queries = append(queries, GymQuery{Class: p.classes})
 	}
 
-	// Preferred class at any gym at preferred time (after now)
-	var preferredQuery2 = GymQuery{}
-	var queryClasses2 = GymClasses{}
-	if time.Now().UTC().Hour() < (preference.PreferredTime - 1) {
-		preferredQuery2.Class = []string{preference.PreferredClass}
-		preferredQuery1.After = time.Date(year, month, day, preference.PreferredTime-1, 0, 0, 0, time.UTC)
-		preferredQuery1.Before = time.Date(year, month, day, preference.PreferredTime+1, 0, 0, 0, time.UTC)
-		queryClasses2, err = QueryClasses(preferredQuery2, dbConfig)
+	var allClasses GymClasses
+	for _, q := range queries {
+		q.After = now
+		q.Before = tomorrow
+		q.Day = p.days
+		q.ExcludeClass = p.blocked
+		q.Timezone = now.Location()
+		classes, err := QueryClasses(q, dbConfig)
 		if err != nil {
 			log.WithFields(log.Fields{"error": err}).Error("Failed to query for preferred classes for a user")
 		}
-
+		allClasses = append(allClasses, classes...)
 	}
 
-	allClasses := append(queryClasses1, queryClasses2...)
-
 	var encountered = map[string]bool{}
 	var deDuped = GymClasses{}
 	for _, class := range allClasses {
@@ -588,197 +835,153 @@ func QueryPreferredClasses(preference UserPreference, dbConfig *Config) (GymClas
 	return deDuped, nil
 }
 
-// StoreUserClass will store a class against a user in the database
-func StoreUserClass(user string, classID string, dbConfig *Config) error {
-	// Get class from ID
+// StoreUserClass will store a class against a user in the database as planned if it hasn't started yet, or as
+// attended once it has. Storing a planned class again after it has started marks it as attended
+// The class is stored even if it clashes with the user's other classes, and any clashes are returned as warnings
+func StoreUserClass(user string, classID string, dbConfig *Config) ([]Conflict, error) {
 	var c GymClass
 	err := dbConfig.DB.One("UUID", classID, &c)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err, "class": classID}).Error("Failed to find class from bolt db")
-		return err
+		return nil, err
+	}
+	if !c.Started(dbConfig.now()) {
+		return StoreUserClassStatus(user, classID, AttendancePlanned, dbConfig)
 	}
 
-	var u UserGymClass
-	err = dbConfig.DB.One("UserID", user, &u)
-	// If the user doesn't exist then create
-	if err == storm.ErrNotFound {
-		u.UserID = user
-		u.Classes = []GymClass{c}
-		err = dbConfig.DB.Save(&u)
+	var a Attendance
+	err = dbConfig.DB.One("ID", attendanceID(user, classID), &a)
+	if err == nil && a.Status == AttendancePlanned {
+		_, err = UpdateAttendanceStatus(user, classID, AttendanceAttended, dbConfig)
 		if err != nil {
-			log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to save new user")
-			return err
+			return nil, err
 		}
-		return nil
-	} else if err != nil {
+		return []Conflict{}, nil
+	} else if err != nil && err != storm.ErrNotFound {
 		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to find classes for user")
-		return err
-	}
-	// Update the classes for the user
-	allC := u.Classes
-	// If it already exists don't add it again
-	if allC.Exists(c) {
-		log.WithFields(log.Fields{"class": c.UUID, "user": user}).Info("Class already exists for user")
-		return nil
-	}
-
-	err = dbConfig.DB.UpdateField(&UserGymClass{UserID: user}, "Classes", append(u.Classes, c))
-	if err != nil {
-		log.WithFields(log.Fields{"error": err, "class": classID, "user": user}).Error("Failed to store user classes")
-		return err
+		return nil, err
 	}
-	return nil
+	return StoreUserClassStatus(user, classID, AttendanceAttended, dbConfig)
 }
 
 // DeleteUserClass will delete a class for a particular user in the database
 func DeleteUserClass(user string, classID string, dbConfig *Config) error {
-	// Get the User
-	var u UserGymClass
-	err := dbConfig.DB.One("UserID", user, &u)
+	var a Attendance
+	err := dbConfig.DB.One("ID", attendanceID(user, classID), &a)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to find user when deleting UserGymClass")
+		log.WithFields(log.Fields{"error": err, "user": user, "class": classID}).Error("Failed to find user class when deleting attendance")
 		return err
 	}
 
-	// Remove the unwanted class from the current classes
-	allClasses := u.Classes
-	allClasses.Delete(classID)
-	// Update the UserGymClass
-	err = dbConfig.DB.Update(&UserGymClass{UserID: user, Classes: allClasses})
+	err = dbConfig.DB.DeleteStruct(&a)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to update user when deleting UserGymClass")
+		log.WithFields(log.Fields{"error": err, "user": user, "class": classID}).Error("Failed to delete attendance for user class")
 		return err
 	}
 	return nil
 }
 
-// QueryClassesByName will take a query string and try parse out the correct query and return the results
-func QueryClassesByName(query string, dbConfig *Config) (GymQuery, error) {
-
-	log.Infof("Querying wit.ai for '%s'", query)
-	accessToken := os.Getenv("WIT_ACCESS_TOKEN")
-	if accessToken == "" {
-		log.Error("Failed to get access token from environment vars")
-		return GymQuery{}, errors.New("No access token found for Wit.ai, please set the environment variable WIT_ACCESS_TOKEN")
-	}
-	client := wit.NewClient(accessToken)
-	request := &wit.MessageRequest{}
-	request.Query = query
-	result, err := client.Message(request)
+// MigrateUserGymClasses converts any UserGymClass records, which embed copies of each class, into Attendance
+// records which reference the class instead. Classes which only exist in a user's history are stored as well
+func MigrateUserGymClasses(dbConfig *Config) error {
+	var legacy []UserGymClass
+	err := dbConfig.DB.All(&legacy)
 	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Error("Failed to query wit.ai")
-		return GymQuery{}, errors.New("Failed to query wit.ai")
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get user classes to migrate")
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
 	}
 
-	var gymQuery GymQuery
-	if len(result.Outcomes) >= 1 {
-
-		outcome := result.Outcomes[0]
-		class := outcome.Entities["gym_classname"]
-		datetime := outcome.Entities["datetime"]
-		location := outcome.Entities["gym_location"]
-
-		// Parse Gyms
-		if len(location) >= 1 {
-			for _, v := range location {
-				gymName := fmt.Sprintf("%v", *v.Value)
-				gymQuery.Gym = append(gymQuery.Gym, GetGymByName(gymName))
+	tx, err := dbConfig.DB.Begin(true)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction for migration")
+		return err
+	}
+	defer tx.Rollback()
+
+	migrated := 0
+	for _, u := range legacy {
+		for _, c := range u.Classes {
+			var existing GymClass
+			err = tx.One("UUID", c.UUID, &existing)
+			if err == storm.ErrNotFound {
+				err = tx.Save(&c)
 			}
-		} else {
-			gymQuery.Gym = []Gym{}
-		}
-
-		// Parse class name
-		if len(class) >= 1 {
-			// For each class found
-			for _, v := range class {
-				// Save the class as a string
-				cls := fmt.Sprintf("%v", *v.Value)
-				gymQuery.Class = append(gymQuery.Class, cls)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err, "class": c.UUID}).Error("Failed to store class when migrating user classes")
+				return err
 			}
-		} else {
-			gymQuery.Class = []string{}
-		}
-
-		// Parse dates
-		if len(datetime) >= 1 {
-			// We're only going to take the first datetime
-			// If it is a date range
-			if *datetime[0].Type == "interval" {
-				if datetime[0].From != nil {
-					after, err := time.Parse("2006-01-02T15:04:05Z07:00", datetime[0].From.Value)
-					if err != nil {
-						gymQuery.After = time.Now()
-					} else {
-						gymQuery.After = after
-					}
-				} else {
-					gymQuery.After = time.Now()
-				}
-				if datetime[0].To != nil {
-					before, err := time.Parse("2006-01-02T15:04:05Z07:00", datetime[0].To.Value)
-					if err != nil {
-						gymQuery.Before = time.Now().AddDate(0, 0, 1)
-					} else {
-						gymQuery.Before = before
-					}
-				} else {
-					gymQuery.Before = time.Now().AddDate(0, 0, 1)
-
-				}
-				log.Infof("Received a date interval parsing %v to %v as range %s to %s", datetime[0].From, datetime[0].To, gymQuery.After, gymQuery.Before)
-
-				// Else if it's just a value
-			} else if *datetime[0].Type == "value" {
-				// If the type of time value is a day, we're going to assume it's between the time provided and 24 hours in the future
-				if *datetime[0].Grain == "day" {
-					dateVal := (*datetime[0].Value).(string)
-					after, err := time.Parse("2006-01-02T15:04:05Z07:00", dateVal)
-					if err != nil {
-						gymQuery.After = time.Now()
-					} else {
-						gymQuery.After = after
-					}
-					gymQuery.Before = after.AddDate(0, 0, 1)
-					// If it's a type of a week, we assume it's between the time provided and 7 days in the future
-				} else if *datetime[0].Grain == "week" {
-					dateVal := (*datetime[0].Value).(string)
-					after, err := time.Parse("2006-01-02T15:04:05Z07:00", dateVal)
-					if err != nil {
-						gymQuery.After = time.Now()
-					} else {
-						gymQuery.After = after
-					}
-					gymQuery.Before = after.AddDate(0, 0, 7)
-				} else {
-					gymQuery.After = time.Now()
-					gymQuery.Before = time.Now().AddDate(0, 0, 7)
-				}
-				log.Infof("Received a date with grain '%v' parsing %s as range %v to %v", *datetime[0].Grain, (*datetime[0].Value).(string), gymQuery.After, gymQuery.Before)
+			a := Attendance{
+				ID:        attendanceID(u.UserID, c.UUID),
+				UserID:    u.UserID,
+				ClassUUID: c.UUID,
+				Status:    AttendanceAttended,
+				Timestamp: c.StartDateTime,
+				History:   []StatusChange{{To: AttendanceAttended, Timestamp: c.StartDateTime}},
 			}
-		} else {
-			gymQuery.After = time.Now().AddDate(0, 0, 0)
-			gymQuery.Before = time.Now().AddDate(0, 0, 7)
-			log.Infof("Couldn't find a datetime so parsing as range %v to %v", gymQuery.After, gymQuery.Before)
+			err = tx.Save(&a)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err, "user": u.UserID, "class": c.UUID}).Error("Failed to store attendance when migrating user classes")
+				return err
+			}
+			migrated++
 		}
+		err = tx.DeleteStruct(&u)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "user": u.UserID}).Error("Failed to remove user classes after migrating")
+			return err
+		}
+	}
 
-		log.Infof("Returning the following query: %v", gymQuery)
-		return gymQuery, nil
-
+	err = tx.Commit()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to commit migration of user classes")
+		return err
 	}
+	log.Infof("Migrated %d classes for %d users to attendance records", migrated, len(legacy))
+	return nil
+}
 
-	log.Info("Failed to get a response from wit.ai")
-	return GymQuery{}, errors.New("Failed to find any classes")
+func attendanceID(user string, classID string) string {
+	return fmt.Sprintf("%s/%s", user, classID)
+}
+
+// QueryClassesByName parses a natural language query into a GymQuery without running it, use SearchClasses
+// to also get the matching classes. Queries are parsed locally with ParseNaturalQuery unless the
+// configuration has an NLU such as WitNLU
+func QueryClassesByName(query string, dbConfig *Config) (GymQuery, error) {
+	now := dbConfig.now()
+	if dbConfig.NLU == nil {
+		return ParseNaturalQueryIn(query, now, now.Location())
+	}
+	intent, err := dbConfig.NLU.Understand(query, now)
+	if err != nil {
+		return GymQuery{}, err
+	}
+	gymQuery := intent.QueryIn(now, now.Location())
+	log.Infof("Returning the following query: %v", gymQuery)
+	return gymQuery, nil
 }
 
 // QueryClasses will query the classes from the stored database and return the results
 func QueryClasses(query GymQuery, dbConfig *Config) (GymClasses, error) {
+	page, err := QueryClassesPage(query, dbConfig)
+	if err != nil {
+		return GymClasses{}, err
+	}
+	return page.Classes, nil
+}
+
+// QueryClassesPage will query the classes from the stored database and return a page of the results
+func QueryClassesPage(query GymQuery, dbConfig *Config) (ClassPage, error) {
 	allClasses := make(GymClasses, 0)
 	var gc GymClasses
 	err := dbConfig.DB.All(&gc)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("Failed to get all stored classes")
-		return GymClasses{}, err
+		return ClassPage{}, err
 	}
 	for _, c := range gc {
 		if c.InQuery(query) {
@@ -786,9 +989,13 @@ func QueryClasses(query GymQuery, dbConfig *Config) (GymClasses, error) {
 		}
 	}
 
-	log.Infof("Returning %d gym classes", len(allClasses))
-	sort.Sort(ByStartDateTime(allClasses))
-	return allClasses, nil
+	page, err := paginate(allClasses, query)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to page through classes")
+		return ClassPage{}, err
+	}
+	log.Infof("Returning %d of %d gym classes", len(page.Classes), page.Total)
+	return page, nil
 }
 
 // GetGymByName returns a Gym based on the name provided
@@ -802,6 +1009,17 @@ func GetGymByName(name string) Gym {
 	return Gym{}
 }
 
+// GetClassByName returns the ClassType with the name provided, ignoring case
+func GetClassByName(name string) ClassType {
+	for _, class := range Classes {
+		if strings.EqualFold(name, class.Name) {
+			return class
+		}
+	}
+	log.WithFields(log.Fields{"name": name}).Debug("Unable to find class")
+	return ClassType{}
+}
+
 // GetGymByID returns a Gym based on the ID provided
 func GetGymByID(ID string) Gym {
 	for _, gym := range Gyms {
@@ -813,6 +1031,17 @@ func GetGymByID(ID string) Gym {
 	return Gym{}
 }
 
+// sameClass checks if two classes describe the same class, ignoring when they were inserted
+func sameClass(a GymClass, b GymClass) bool {
+	return a.UUID == b.UUID &&
+		a.Gym == b.Gym &&
+		a.Name == b.Name &&
+		a.Location == b.Location &&
+		a.StartDateTime.Equal(b.StartDateTime) &&
+		a.EndDateTime.Equal(b.EndDateTime) &&
+		a.Instructor == b.Instructor
+}
+
 func compareClassName(query *GymQuery, class *GymClass) bool {
 	if len(query.Class) == 0 {
 		return true
@@ -853,6 +1082,127 @@ func compareClassBeforeTime(query *GymQuery, class *GymClass) bool {
 	return class.StartDateTime.Before(query.Before)
 }
 
+func compareClassDay(query *GymQuery, class *GymClass) bool {
+	if len(query.Day) == 0 {
+		return true
+	}
+	day := query.localTime(class.StartDateTime).Weekday()
+	for _, d := range query.Day {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func compareClassTimeOfDay(query *GymQuery, class *GymClass) bool {
+	if query.FromTime == 0 && query.ToTime == 0 {
+		return true
+	}
+	start := query.localTime(class.StartDateTime)
+	t := NewTimeOfDay(start.Hour(), start.Minute())
+	to := query.ToTime
+	if to == 0 {
+		to = NewTimeOfDay(24, 0)
+	}
+	// The window wraps around midnight e.g. 22:00 - 02:00
+	if query.FromTime > to {
+		return t >= query.FromTime || t < to
+	}
+	return t >= query.FromTime && t < to
+}
+
+func compareClassLocation(query *GymQuery, class *GymClass) bool {
+	if len(query.Location) == 0 {
+		return true
+	}
+	for _, l := range query.Location {
+		if strings.EqualFold(class.Location, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareClassDuration(query *GymQuery, class *GymClass) bool {
+	d := class.Duration()
+	if query.MinDuration != 0 && d < query.MinDuration {
+		return false
+	}
+	if query.MaxDuration != 0 && d > query.MaxDuration {
+		return false
+	}
+	return true
+}
+
+func compareClassCategory(query *GymQuery, class *GymClass) bool {
+	if len(query.Category) == 0 {
+		return true
+	}
+	category := class.Category()
+	for _, c := range query.Category {
+		if strings.EqualFold(category, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareClassExclusions(query *GymQuery, class *GymClass) bool {
+	for _, c := range query.ExcludeClass {
+		if strings.Contains(strings.ToLower(class.Name), strings.ToLower(c)) {
+			return false
+		}
+	}
+	for _, g := range query.ExcludeGym {
+		if strings.ToLower(class.Gym) == strings.ToLower(g.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// now returns the current time from the configuration's clock in the configuration's timezone
+func (c *Config) now() time.Time {
+	now := time.Now()
+	if c.Clock != nil {
+		now = c.Clock()
+	}
+	if c.Timezone != nil {
+		return now.In(c.Timezone)
+	}
+	return localTime(now)
+}
+
+// weeklyTarget returns the configured WeeklyTarget or DefaultWeeklyTarget when it isn't set
+func (c *Config) weeklyTarget() int {
+	if c.WeeklyTarget > 0 {
+		return c.WeeklyTarget
+	}
+	return DefaultWeeklyTarget
+}
+
+// targetMix returns the configured TargetMix or DefaultTargetMix when it isn't set
+func (c *Config) targetMix() TargetMix {
+	if len(c.TargetMix) > 0 {
+		return c.TargetMix
+	}
+	return DefaultTargetMix
+}
+
+// localTime returns the time in the timezone of the gyms
+func localTime(t time.Time) time.Time {
+	return t.In(gymLocation)
+}
+
+// localTime returns t in the query's timezone, or the gyms' local time when it doesn't have one
+func (q *GymQuery) localTime(t time.Time) time.Time {
+	if q.Timezone == nil {
+		return localTime(t)
+	}
+	return t.In(q.Timezone)
+}
+
 func init() {
 	debug := os.Getenv("DEBUG")
 	if debug == "true" {
@@ -863,4 +1213,11 @@ func init() {
 		log.SetLevel(log.InfoLevel)
 	}
 	log.SetOutput(os.Stdout)
+
+	loc, err := time.LoadLocation(TimeZone)
+	if err != nil {
+		log.WithFields(log.Fields{"value": err}).Error("Failed to get timezone, using UTC")
+		loc = time.UTC
+	}
+	gymLocation = loc
 }
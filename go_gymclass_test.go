@@ -95,6 +95,34 @@ func clearDB(config *Config) error {
 			return err
 		}
 	}
+	err = config.DB.Drop("Attendance")
+	if err != nil {
+		if err.Error() != "bucket not found" {
+			fmt.Printf("Failed to drop Attendance: %s", err)
+			return err
+		}
+	}
+	err = config.DB.Drop("User")
+	if err != nil {
+		if err.Error() != "bucket not found" {
+			fmt.Printf("Failed to drop User: %s", err)
+			return err
+		}
+	}
+	err = config.DB.Drop("SavedSearch")
+	if err != nil {
+		if err.Error() != "bucket not found" {
+			fmt.Printf("Failed to drop SavedSearch: %s", err)
+			return err
+		}
+	}
+	err = config.DB.Drop("UserProfile")
+	if err != nil {
+		if err.Error() != "bucket not found" {
+			fmt.Printf("Failed to drop UserProfile: %s", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -318,14 +346,37 @@ func TestStoreClasses(t *testing.T) {
 	if err != nil {
 		t.Errorf("Failed to clear database: %s", err)
 	}
-	err = StoreClasses(testClasses, testConfig)
+	defer testConfig.DB.Close()
+
+	result, err := StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
+	}
+	assert.Equal(t, StoreResult{Inserted: len(testClasses)}, result, "Did not insert all classes")
 
+	var stored GymClass
+	err = testConfig.DB.One("UUID", testClasses[0].UUID, &stored)
+	if err != nil {
+		t.Errorf("Failed to get stored class: %s", err)
 	}
-	//TODO: Check that I stored the right number of classes
-	defer testConfig.DB.Close()
+	assert.False(t, stored.InsertDateTime.IsZero(), "InsertDateTime was not set on insert")
 
+	// Storing the same classes again with one changed should only update that class
+	updated := make(GymClasses, len(testClasses))
+	copy(updated, testClasses)
+	updated[0].Location = "Studio 3"
+	result, err = StoreClasses(updated, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes: %s", err)
+	}
+	assert.Equal(t, StoreResult{Updated: 1, Unchanged: len(testClasses) - 1}, result, "Did not update changed class")
+
+	var restored GymClass
+	err = testConfig.DB.One("UUID", testClasses[0].UUID, &restored)
+	if err != nil {
+		t.Errorf("Failed to get stored class: %s", err)
+	}
+	assert.True(t, stored.InsertDateTime.Equal(restored.InsertDateTime), "InsertDateTime changed on update")
 }
 
 type queryClassTest struct {
@@ -346,7 +397,7 @@ func TestQueryClasses(t *testing.T) {
 		t.Errorf("Failed to clear database %s", err)
 	}
 	defer testConfig.DB.Close()
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes %s", err)
 	}
@@ -440,7 +491,7 @@ func TestStoreUserClass(t *testing.T) {
 	}
 
 	// Store the GymClasess
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
 
@@ -455,9 +506,30 @@ func TestStoreUserClass(t *testing.T) {
 		{"456", testClasses[4]},
 	}
 	for _, test := range storeUserClassTests {
-		err := StoreUserClass(test.user, test.class.UUID, testConfig)
+		_, err := StoreUserClass(test.user, test.class.UUID, testConfig)
 		assert.NoError(t, err, "Failed to store user class without error")
 	}
+
+	// Classes which haven't started yet are planned until they have
+	statuses := map[string]string{
+		testClasses[0].UUID: AttendanceAttended,
+		testClasses[1].UUID: AttendanceAttended,
+		testClasses[2].UUID: AttendancePlanned,
+		testClasses[3].UUID: AttendancePlanned,
+	}
+	attendance, err := queryAttendance("123", testConfig)
+	assert.NoError(t, err)
+	for _, a := range attendance {
+		assert.Equal(t, statuses[a.ClassUUID], a.Status, "Wrong status for class %s", a.ClassUUID)
+	}
+	testConfig.Clock = func() time.Time { return testClasses[2].StartDateTime }
+	_, err = StoreUserClass("123", testClasses[2].UUID, testConfig)
+	assert.NoError(t, err)
+	var a Attendance
+	err = testConfig.DB.One("ID", attendanceID("123", testClasses[2].UUID), &a)
+	assert.NoError(t, err)
+	assert.Equal(t, AttendanceAttended, a.Status, "A planned class wasn't attended once it started")
+	assert.Len(t, a.History, 2)
 }
 
 type queryUserClassTest struct {
@@ -478,7 +550,7 @@ func TestQueryUserClasses(t *testing.T) {
 	defer testConfig.DB.Close()
 	// Store Classes
 	// Store the GymClasess
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
 	}
@@ -492,7 +564,7 @@ func TestQueryUserClasses(t *testing.T) {
 		{"456", testClasses[4]},
 	}
 	for _, test := range storeUserClassTests {
-		err := StoreUserClass(test.user, test.class.UUID, testConfig)
+		_, err := StoreUserClass(test.user, test.class.UUID, testConfig)
 		if err != nil {
 			t.Errorf("Error when storing user classes: %s", err)
 
@@ -531,6 +603,9 @@ func TestQueryUserPreferences(t *testing.T) {
 	}
 	defer testConfig.DB.Close()
 
+	// All of the classes have started
+	testConfig.Clock = func() time.Time { return now.Add(6 * time.Hour) }
+
 	queryUserPreferencesTests := []queryUserPreferencesTest{
 		{
 			"123",
@@ -542,7 +617,7 @@ func TestQueryUserPreferences(t *testing.T) {
 		},
 	}
 	// Store the GymClasess
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
 	}
@@ -556,7 +631,7 @@ func TestQueryUserPreferences(t *testing.T) {
 		{"456", testClasses[4]},
 	}
 	for _, test := range storeUserClassTests {
-		err := StoreUserClass(test.user, test.class.UUID, testConfig)
+		_, err := StoreUserClass(test.user, test.class.UUID, testConfig)
 		if err != nil {
 			t.Errorf("Error when storing user classes: %s", err)
 
@@ -589,9 +664,11 @@ func TestQueryPreferredClassesTest(t *testing.T) {
 		t.Errorf("Failed to clear database %s", err)
 	}
 	defer testConfig.DB.Close()
+	// The test classes and preferred time are in UTC
+	testConfig.Timezone = time.UTC
 
 	// Store the GymClasess
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
 	}
@@ -605,21 +682,75 @@ func TestQueryPreferredClassesTest(t *testing.T) {
 		{"456", testClasses[4]},
 	}
 	for _, test := range storeUserClassTests {
-		err := StoreUserClass(test.user, test.class.UUID, testConfig)
+		_, err := StoreUserClass(test.user, test.class.UUID, testConfig)
 		if err != nil {
 			t.Errorf("Error when storing user classes: %s", err)
 
 		}
 	}
+	// Profiles for a new user with no history and a user who doesn't want to do RPM
+	err = StoreUserProfile(UserProfile{UserID: "456", HomeGym: "britomart", PreferredClasses: []string{"rpm"}}, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing user profile: %s", err)
+	}
+	err = StoreUserProfile(UserProfile{UserID: "789", HomeGym: "city", BlockedClasses: []string{"RPM"}}, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing user profile: %s", err)
+	}
+
+	// A user in New York who likes BODYBALANCE around when it starts in their timezone
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Errorf("Failed to load timezone %s", err)
+	}
+	bodybalance := testClasses[3].StartDateTime.In(newYork)
+	err = StoreUserProfile(UserProfile{
+		UserID:           "321",
+		Timezone:         newYork.String(),
+		PreferredClasses: []string{"BODYBALANCE"},
+		PreferredFrom:    NewTimeOfDay(bodybalance.Hour(), 0),
+		PreferredTo:      NewTimeOfDay(bodybalance.Hour()+1, 0)}, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing user profile: %s", err)
+	}
+	// A new user who has only chosen a class
+	err = StoreUserProfile(UserProfile{UserID: "654", PreferredClasses: []string{"BODYBALANCE"}}, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing user profile: %s", err)
+	}
+	classOnlyClasses := 0
+	if testClasses[3].StartDateTime.Before(startOfDay(now).AddDate(0, 0, 1)) {
+		classOnlyClasses = 1
+	}
+
+	// The class is only found when it is still today in New York
+	newYorkClasses := 0
+	if bodybalance.Before(startOfDay(now.In(newYork)).AddDate(0, 0, 1)) {
+		newYorkClasses = 1
+	}
+
+	// Preferred times are in the configuration's timezone
+	localHour := now.Hour()
 	var queryPreferredClassesTests = []queryPreferredClassesTest{
 		{
 			UserPreference{
 				User:           "123",
 				PreferredGym:   "city",
 				PreferredClass: "RPM",
-				PreferredTime:  now.Hour() + 2,
+				PreferredTime:  localHour + 2,
 				PreferredDay:   int(now.Weekday())},
-			3},
+			2},
+		{UserPreference{User: "456"}, 1},
+		{
+			UserPreference{
+				User:           "789",
+				PreferredGym:   "city",
+				PreferredClass: "RPM",
+				PreferredTime:  localHour + 4},
+			2},
+		{UserPreference{User: "000"}, 0},
+		{UserPreference{User: "321"}, newYorkClasses},
+		{UserPreference{User: "654"}, classOnlyClasses},
 	}
 
 	for _, test := range queryPreferredClassesTests {
@@ -649,9 +780,11 @@ func TestQueryUserStatistics(t *testing.T) {
 		t.Errorf("Failed to clear database %s", err)
 	}
 	defer testConfig.DB.Close()
+	// All of the classes have started
+	testConfig.Clock = func() time.Time { return now.Add(6 * time.Hour) }
 
 	// Store the GymClasess
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
 	}
@@ -665,7 +798,7 @@ func TestQueryUserStatistics(t *testing.T) {
 		{"456", testClasses[4]},
 	}
 	for _, test := range storeUserClassTests {
-		err := StoreUserClass(test.user, test.class.UUID, testConfig)
+		_, err := StoreUserClass(test.user, test.class.UUID, testConfig)
 		if err != nil {
 			t.Errorf("Error when storing user classes: %s", err)
 
@@ -673,13 +806,15 @@ func TestQueryUserStatistics(t *testing.T) {
 	}
 
 	city := GetGymByName("city")
-	_, week := now.ISOWeek()
+	first := testClasses[0].StartDateTime.In(gymLocation)
+	year, week := first.ISOWeek()
+	monday := time.Date(first.Year(), first.Month(), first.Day()-(int(first.Weekday())+6)%7, 0, 0, 0, 0, gymLocation)
 	queryUserStatistics := []queryUserStatisticsTest{
 		{
 			"123",
 			UserStatistics{
 				TotalClasses:   4,
-				ClassesPerWeek: 32,
+				ClassesPerWeek: 4,
 				LastClassDate:  time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+3, 0, 0, 0, time.UTC),
 				GymPreferences: []GymPreference{
 					{
@@ -687,13 +822,14 @@ func TestQueryUserStatistics(t *testing.T) {
 						Preference: 1.0,
 					},
 				},
+				// Ties go to the class gone to most recently
 				ClassPreferences: []ClassPreference{
-					{"BODYPUMP", 0.25},
 					{"RPM", 0.5},
 					{"BODYBALANCE", 0.25},
+					{"BODYPUMP", 0.25},
 				},
 				WorkOutFrequency: []WorkOutFrequency{
-					{week, 4},
+					{Year: year, Week: week, Start: monday, Count: 4},
 				},
 			},
 		},
@@ -727,7 +863,7 @@ func TestDeleteUserClass(t *testing.T) {
 		t.Errorf("Failed to clear database %s", err)
 	}
 	// Store the GymClasess
-	err = StoreClasses(testClasses, testConfig)
+	_, err = StoreClasses(testClasses, testConfig)
 	if err != nil {
 		t.Errorf("Error when storing classes: %s", err)
 	}
@@ -741,7 +877,7 @@ func TestDeleteUserClass(t *testing.T) {
 		{"456", testClasses[4]},
 	}
 	for _, test := range storeUserClassTests {
-		err := StoreUserClass(test.user, test.class.UUID, testConfig)
+		_, err := StoreUserClass(test.user, test.class.UUID, testConfig)
 		if err != nil {
 			t.Errorf("Error when storing user classes: %s", err)
 
@@ -762,10 +898,48 @@ func TestDeleteUserClass(t *testing.T) {
 	_ = os.Remove("gym.db")
 }
 
+func TestMigrateUserGymClasses(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	// Clear the DB
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+
+	// Only the first class is stored on its own, the second only exists in the user's history
+	_, err = StoreClasses(testClasses[:1], testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes: %s", err)
+	}
+	err = testConfig.DB.Save(&UserGymClass{UserID: "123", Classes: GymClasses{testClasses[0], testClasses[1]}})
+	if err != nil {
+		t.Errorf("Error when storing legacy user classes: %s", err)
+	}
+
+	err = MigrateUserGymClasses(testConfig)
+	assert.NoError(t, err, "Failed to migrate user classes")
+
+	classes, err := QueryUserClasses("123", testConfig)
+	if err != nil {
+		t.Errorf("Failed to get user classes %s", err)
+	}
+	assert.Equal(t, 2, len(classes), "Did not get migrated classes for user")
+
+	var legacy []UserGymClass
+	err = testConfig.DB.All(&legacy)
+	if err != nil {
+		t.Errorf("Failed to get legacy user classes %s", err)
+	}
+	assert.Equal(t, 0, len(legacy), "Legacy user classes were not removed")
+}
+
 // TODO: Add more test cases
 func TestDeleteClass(t *testing.T) {
-	classes := testClasses
-
+	classes := make(GymClasses, len(testClasses))
 	copy(classes, testClasses)
 	ok := classes.Delete(testClasses[1].UUID)
 	if !ok {
@@ -792,6 +966,11 @@ func TestExistsClass(t *testing.T) {
 			GymClass{},
 			false,
 		},
+		{
+			testClasses,
+			GymClass{UUID: testClasses[1].UUID, Name: "RPM 45"},
+			true,
+		},
 	}
 
 	for _, test := range testExistsClassTests {
@@ -800,3 +979,70 @@ func TestExistsClass(t *testing.T) {
 	}
 
 }
+
+type inQueryTest struct {
+	name     string
+	query    GymQuery
+	expected bool
+}
+
+func TestInQuery(t *testing.T) {
+	auckland, err := time.LoadLocation(TimeZone)
+	if err != nil {
+		t.Errorf("Failed to load timezone %s", err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Errorf("Failed to load timezone %s", err)
+	}
+	// A Tuesday morning RPM class, stored in UTC as it would be after a round trip through the database
+	class := GymClass{
+		Gym:           "city",
+		Name:          "RPM",
+		Location:      "RPM Studio",
+		StartDateTime: time.Date(2018, 4, 3, 6, 30, 0, 0, auckland).UTC(),
+		EndDateTime:   time.Date(2018, 4, 3, 7, 15, 0, 0, auckland).UTC(),
+	}
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	inQueryTests := []inQueryTest{
+		{"Empty query", GymQuery{}, true},
+		{"RPM before work on weekdays", GymQuery{Class: []string{"rpm"}, Day: weekdays, FromTime: NewTimeOfDay(6, 0), ToTime: NewTimeOfDay(8, 0)}, true},
+		{"Weekends", GymQuery{Day: []time.Weekday{time.Saturday, time.Sunday}}, false},
+		{"Evenings", GymQuery{FromTime: NewTimeOfDay(17, 0)}, false},
+		{"Window wrapping midnight", GymQuery{FromTime: NewTimeOfDay(22, 0), ToTime: NewTimeOfDay(7, 0)}, true},
+		{"Window ending at start", GymQuery{ToTime: NewTimeOfDay(6, 30)}, false},
+		{"Monday evening in London", GymQuery{Day: []time.Weekday{time.Monday}, FromTime: NewTimeOfDay(19, 0), Timezone: london}, true},
+		{"Tuesday morning in London", GymQuery{Day: weekdays[1:], FromTime: NewTimeOfDay(6, 0), ToTime: NewTimeOfDay(8, 0), Timezone: london}, false},
+		{"Studio", GymQuery{Location: []string{"rpm studio"}}, true},
+		{"Other studio", GymQuery{Location: []string{"Studio 1"}}, false},
+		{"Minimum duration", GymQuery{MinDuration: 45 * time.Minute}, true},
+		{"Minimum duration too long", GymQuery{MinDuration: time.Hour}, false},
+		{"Maximum duration", GymQuery{MaxDuration: 30 * time.Minute}, false},
+		{"Category", GymQuery{Category: []string{CategoryCardio}}, true},
+		{"Other category", GymQuery{Category: []string{CategoryStrength, CategoryCore}}, false},
+		{"Excluded class", GymQuery{ExcludeClass: []string{"RPM"}}, false},
+		{"Other excluded class", GymQuery{ExcludeClass: []string{"YOGA"}}, true},
+		{"Excluded gym", GymQuery{ExcludeGym: []Gym{GetGymByName("city")}}, false},
+		{"Other excluded gym", GymQuery{ExcludeGym: []Gym{GetGymByName("takapuna")}}, true},
+	}
+	for _, test := range inQueryTests {
+		assert.Equal(t, test.expected, class.InQuery(test.query), "Failed %s test", test.name)
+	}
+}
+
+func TestParseInstructor(t *testing.T) {
+	var tests = []struct {
+		summary  string
+		expected string
+	}{
+		{"RPM Sun 8:20am (Jane)", "Jane"},
+		{"AKB RPM Sun 9:00am ( Sam Smith )", "Sam Smith"},
+		{"RPM Sun 8:20am (Staff)", ""},
+		{"LM Auckland City BodyPump", ""},
+		{"BODYPUMP (Virtual) (Jane)", "Jane"},
+		{"BODYPUMP )Jane(", ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, parseInstructor(test.summary), "Instructor of %s", test.summary)
+	}
+}
@@ -0,0 +1,61 @@
+package lm
+
+import (
+	"time"
+)
+
+// Heatmap counts classes by the day of the week and the hour they start. Days are indexed from Sunday like
+// time.Weekday, so heatmap[time.Monday][6] is the number of classes starting between 6am and 7am on Mondays
+type Heatmap [7][24]int
+
+// ClassBreakdown describes when a user goes to one type of class
+type ClassBreakdown struct {
+	Class   string   `json:"class"`
+	Count   int      `json:"count"`
+	Share   float64  `json:"share"`
+	Heatmap Heatmap  `json:"heatmap"`
+	Days    []Ranked `json:"days"`
+	Hours   []Ranked `json:"hours"`
+}
+
+// Heatmap counts the classes by the day and hour they start in loc
+func (g GymClasses) Heatmap(loc *time.Location) Heatmap {
+	var h Heatmap
+	for _, c := range g {
+		start := c.StartDateTime.In(loc)
+		h[start.Weekday()][start.Hour()]++
+	}
+	return h
+}
+
+// ClassBreakdowns returns when each type of class is gone to in loc, ranked in the same order as RankClasses
+func (g GymClasses) ClassBreakdowns(loc *time.Location) []ClassBreakdown {
+	byClass := make(map[string]GymClasses)
+	for _, c := range g {
+		byClass[c.Name] = append(byClass[c.Name], c)
+	}
+	breakdowns := []ClassBreakdown{}
+	for _, r := range g.RankClasses(0) {
+		classes := byClass[r.Key]
+		breakdowns = append(breakdowns, ClassBreakdown{
+			Class:   r.Key,
+			Count:   r.Count,
+			Share:   r.Share,
+			Heatmap: classes.Heatmap(loc),
+			Days:    classes.RankDays(loc, 0),
+			Hours:   classes.RankHours(loc, 0),
+		})
+	}
+	return breakdowns
+}
+
+// Total returns the number of classes in the heatmap
+func (h Heatmap) Total() int {
+	total := 0
+	for _, hours := range h {
+		for _, count := range hours {
+			total += count
+		}
+	}
+	return total
+}
@@ -0,0 +1,51 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeatmap(t *testing.T) {
+	// The classes are on Tuesday and Wednesday in Auckland, the day after in UTC
+	monday := time.Date(2018, 6, 4, 18, 0, 0, 0, time.UTC)
+	tuesday := time.Date(2018, 6, 5, 18, 0, 0, 0, time.UTC)
+	nextMonday := time.Date(2018, 6, 11, 18, 0, 0, 0, time.UTC)
+	wednesday := time.Date(2018, 6, 6, 5, 30, 0, 0, time.UTC)
+	classes := GymClasses{
+		{Name: "RPM", StartDateTime: monday},
+		{Name: "RPM", StartDateTime: tuesday},
+		{Name: "RPM", StartDateTime: nextMonday},
+		{Name: "BODYPUMP", StartDateTime: wednesday},
+	}
+
+	var expected Heatmap
+	expected[time.Tuesday][6] = 2
+	expected[time.Wednesday][6] = 1
+	expected[time.Wednesday][17] = 1
+	h := classes.Heatmap(gymLocation)
+	assert.Equal(t, expected, h)
+	assert.Equal(t, 4, h.Total())
+
+	assert.Equal(t, []Ranked{{"Tuesday", 2, 0.5, nextMonday}, {"Wednesday", 2, 0.5, wednesday}}, classes.RankDays(gymLocation, 0))
+	assert.Equal(t, []Ranked{{"06:00", 3, 0.75, nextMonday}, {"17:00", 1, 0.25, wednesday}}, classes.RankHours(gymLocation, 0))
+	// Ties go to the day with the most recent class
+	assert.Equal(t, []Ranked{{"Monday", 2, 0.5, nextMonday}, {"Wednesday", 1, 0.25, wednesday}, {"Tuesday", 1, 0.25, tuesday}}, classes.RankDays(time.UTC, 0))
+
+	breakdowns := classes.ClassBreakdowns(gymLocation)
+	if assert.Len(t, breakdowns, 2) {
+		assert.Equal(t, "RPM", breakdowns[0].Class)
+		assert.Equal(t, 3, breakdowns[0].Count)
+		assert.Equal(t, 0.75, breakdowns[0].Share)
+		assert.Equal(t, 2, breakdowns[0].Heatmap[time.Tuesday][6])
+		assert.Equal(t, []Ranked{{"Tuesday", 2, 2.0 / 3.0, nextMonday}, {"Wednesday", 1, 1.0 / 3.0, tuesday}}, breakdowns[0].Days)
+		assert.Equal(t, []Ranked{{"06:00", 3, 1, nextMonday}}, breakdowns[0].Hours)
+		assert.Equal(t, "BODYPUMP", breakdowns[1].Class)
+		assert.Equal(t, []Ranked{{"17:00", 1, 1, wednesday}}, breakdowns[1].Hours)
+	}
+
+	assert.Equal(t, Heatmap{}, GymClasses{}.Heatmap(gymLocation))
+	assert.Empty(t, GymClasses{}.RankDays(gymLocation, 0))
+	assert.Empty(t, GymClasses{}.ClassBreakdowns(gymLocation))
+}
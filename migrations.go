@@ -0,0 +1,102 @@
+package lm
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// The bucket and key the schema version of the database is stored under
+const (
+	schemaBucket     = "Schema"
+	schemaVersionKey = "version"
+)
+
+// migrations upgrade the database one version at a time, the migration at index i upgrades a database at
+// version i to version i + 1. New migrations must only ever be added to the end
+var migrations = []func(*Config) error{
+	MigrateUserGymClasses,
+	migrateUserEmails,
+}
+
+// SchemaVersion returns the version of the database, which is zero before it has ever been migrated
+func SchemaVersion(dbConfig *Config) (int, error) {
+	var version int
+	err := dbConfig.DB.Get(schemaBucket, schemaVersionKey, &version)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get database schema version")
+		return 0, err
+	}
+	return version, nil
+}
+
+// Migrate runs each migration the database hasn't had yet, recording the version after each one so that
+// they only ever run once
+func Migrate(dbConfig *Config) error {
+	version, err := SchemaVersion(dbConfig)
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		err = migrations[version](dbConfig)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "version": version + 1}).Error("Failed to migrate database")
+			return err
+		}
+		err = dbConfig.DB.Set(schemaBucket, schemaVersionKey, version+1)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "version": version + 1}).Error("Failed to store database schema version")
+			return err
+		}
+		log.Infof("Migrated database to version %d", version+1)
+	}
+	return nil
+}
+
+// migrateUserEmails indexes the email addresses of users stored before they were indexed, and lower cases
+// any stored before they were normalised so that GetUserByEmail can find them
+func migrateUserEmails(dbConfig *Config) error {
+	tx, err := dbConfig.DB.Begin(true)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction for migrating users")
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.ReIndex(&User{})
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to index users")
+		return err
+	}
+	var users []User
+	err = tx.All(&users)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get users to migrate")
+		return err
+	}
+	normalised := 0
+	for _, u := range users {
+		email := normaliseEmail(u.Email)
+		if email == u.Email {
+			continue
+		}
+		u.Email = email
+		err = tx.Save(&u)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "user": u.ID}).Error("Failed to normalise email address of user")
+			return err
+		}
+		normalised++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to commit migration of users")
+		return err
+	}
+	log.Infof("Normalised the email addresses of %d users", normalised)
+	return nil
+}
@@ -0,0 +1,61 @@
+package lm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	version, err := SchemaVersion(testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version, "A new database should be fully migrated")
+
+	// A user stored before email addresses were normalised, in a database which has never been migrated
+	legacy := User{ID: "123", Name: "Jane Smith", Email: "Jane.Smith@Example.com"}
+	err = testConfig.DB.Save(&legacy)
+	if err != nil {
+		t.Errorf("Error when storing legacy user: %s", err)
+	}
+	err = testConfig.DB.Drop(schemaBucket)
+	if err != nil {
+		t.Errorf("Error when removing schema version: %s", err)
+	}
+	_, err = GetUserByEmail("jane.smith@example.com", testConfig)
+	assert.Error(t, err, "The legacy user shouldn't be found before migrating")
+	testConfig.DB.Close()
+
+	testConfig, err = NewConfig()
+	if err != nil {
+		t.Errorf("Failed to open database %s", err)
+		return
+	}
+	defer testConfig.DB.Close()
+	user, err := GetUserByEmail("Jane.Smith@example.com", testConfig)
+	assert.NoError(t, err, "The legacy user should be found after migrating")
+	assert.Equal(t, "123", user.ID)
+	assert.Equal(t, "jane.smith@example.com", user.Email)
+	version, err = SchemaVersion(testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	// Migrations only run once
+	other := User{ID: "456", Email: "John@Example.com"}
+	err = testConfig.DB.Save(&other)
+	if err != nil {
+		t.Errorf("Error when storing user: %s", err)
+	}
+	assert.NoError(t, Migrate(testConfig))
+	err = testConfig.DB.One("ID", "456", &other)
+	assert.NoError(t, err)
+	assert.Equal(t, "John@Example.com", other.Email, "The migration ran again")
+}
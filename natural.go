@@ -0,0 +1,415 @@
+package lm
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ClassAliases maps the other names people use for classes to the class name in the catalogue
+var ClassAliases = map[string]string{
+	"pump":         "BODYPUMP",
+	"body pump":    "BODYPUMP",
+	"balance":      "BODYBALANCE",
+	"body balance": "BODYBALANCE",
+	"attack":       "BODYATTACK",
+	"body attack":  "BODYATTACK",
+	"combat":       "BODYCOMBAT",
+	"body combat":  "BODYCOMBAT",
+	"step":         "BODYSTEP",
+	"body step":    "BODYSTEP",
+	"jam":          "BODYJAM",
+	"body jam":     "BODYJAM",
+	"vive":         "BODYVIVE",
+	"body vive":    "BODYVIVE",
+	"shbam":        "SH'BAM",
+	"cx":           "CXWORX",
+	"cx worx":      "CXWORX",
+	"spin":         "RPM",
+	"spinning":     "RPM",
+	"cycle":        "RPM",
+	"cycling":      "RPM",
+	"plyo":         "GRIT PLYO",
+	"grit":         "GRIT",
+}
+
+// GymAliases maps the other names people use for gyms to the gym's name
+var GymAliases = map[string]string{
+	"auckland city": "city",
+	"cbd":           "city",
+	"taka":          "takapuna",
+	"north shore":   "takapuna",
+}
+
+// The local time windows used for parts of the day
+var dayParts = map[string][2]TimeOfDay{
+	"morning":   {NewTimeOfDay(5, 0), NewTimeOfDay(12, 0)},
+	"lunch":     {NewTimeOfDay(11, 0), NewTimeOfDay(14, 0)},
+	"lunchtime": {NewTimeOfDay(11, 0), NewTimeOfDay(14, 0)},
+	"afternoon": {NewTimeOfDay(12, 0), NewTimeOfDay(17, 0)},
+	"evening":   {NewTimeOfDay(17, 0), 0},
+	"tonight":   {NewTimeOfDay(17, 0), 0},
+}
+
+var negations = map[string]bool{"not": true, "no": true, "except": true, "without": true, "excluding": true}
+
+// naturalParser holds the state of a query as it is being parsed
+type naturalParser struct {
+	now        time.Time
+	query      GymQuery
+	negate     bool
+	dateSet    bool
+	timeSet    bool
+	recognised int
+}
+
+// ParseNaturalQuery parses a query such as "rpm at city next tuesday evening" into a GymQuery without needing an
+// external service. Class names and gyms are matched against the catalogue and their aliases, and relative dates
+// and times are worked out from now in the gyms' timezone. Without a date the query covers the next 7 days
+func ParseNaturalQuery(query string, now time.Time) (GymQuery, error) {
+	return ParseNaturalQueryIn(query, now, gymLocation)
+}
+
+// ParseNaturalQueryIn parses a query like ParseNaturalQuery, working out relative dates such as "today" and
+// "tonight" in the timezone loc
+func ParseNaturalQueryIn(query string, now time.Time, loc *time.Location) (GymQuery, error) {
+	p := naturalParser{now: now.In(loc)}
+	words := naturalWords(query)
+	for i := 0; i < len(words); {
+		n := p.match(words, i)
+		if n == 0 {
+			log.WithFields(log.Fields{"word": words[i]}).Debug("Ignoring word in query")
+			n = 1
+		} else if !negations[words[i]] {
+			// A negation only applies to the next thing recognised
+			p.recognised++
+			p.negate = false
+		}
+		i += n
+	}
+	if p.recognised == 0 {
+		return GymQuery{}, errors.New("Unable to find any classes, gyms or times in the query")
+	}
+
+	if !p.dateSet {
+		p.query.After = now
+		p.query.Before = now.AddDate(0, 0, 7)
+		// A time of day on its own means the next time that window comes around
+		if p.timeSet && len(p.query.Day) == 0 {
+			today := startOfDay(p.now)
+			p.query.Before = today.AddDate(0, 0, 1)
+			// Once today's window has passed use tomorrow's
+			end := p.query.ToTime
+			if end == 0 {
+				end = NewTimeOfDay(24, 0)
+			}
+			if NewTimeOfDay(p.now.Hour(), p.now.Minute()) >= end {
+				p.query.After = today.AddDate(0, 0, 1)
+				p.query.Before = today.AddDate(0, 0, 2)
+			}
+		}
+	}
+	log.Infof("Parsed '%s' as the query: %s", query, FormatQuery(p.query))
+	return p.query, nil
+}
+
+// match tries to recognise the words starting at i and returns how many words were used
+func (p *naturalParser) match(words []string, i int) int {
+	word := words[i]
+	next := ""
+	if i+1 < len(words) {
+		next = words[i+1]
+	}
+
+	if negations[word] {
+		p.negate = true
+		return 1
+	}
+	if n := p.matchDate(word, next); n > 0 {
+		return n
+	}
+	if n := p.matchTime(words, i); n > 0 {
+		return n
+	}
+	// Try the longest phrases first so "grit strength" isn't read as "grit"
+	for n := 3; n >= 1; n-- {
+		if i+n > len(words) {
+			continue
+		}
+		phrase := strings.Join(words[i:i+n], " ")
+		if class := naturalClass(phrase); class != "" {
+			if p.negate {
+				p.query.ExcludeClass = append(p.query.ExcludeClass, class)
+			} else {
+				p.query.Class = append(p.query.Class, class)
+			}
+			return n
+		}
+		if gym := naturalGym(phrase); gym.Name != "" {
+			if p.negate {
+				p.query.ExcludeGym = append(p.query.ExcludeGym, gym)
+			} else {
+				p.query.Gym = append(p.query.Gym, gym)
+			}
+			return n
+		}
+		// Queries can't exclude a category so every class in it is excluded instead
+		if n == 1 && isCategory(phrase) {
+			if p.negate {
+				p.query.ExcludeClass = append(p.query.ExcludeClass, classesInCategory(phrase)...)
+			} else {
+				p.query.Category = append(p.query.Category, phrase)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// matchDate recognises relative dates such as "tomorrow", "this weekend" and "next tuesday"
+func (p *naturalParser) matchDate(word string, next string) int {
+	today := startOfDay(p.now)
+	switch word {
+	case "today":
+		p.setDates(today, today.AddDate(0, 0, 1))
+		return 1
+	case "tonight":
+		p.setDates(today, today.AddDate(0, 0, 1))
+		p.setTimes(dayParts[word])
+		return 1
+	case "tomorrow":
+		p.setDates(today.AddDate(0, 0, 1), today.AddDate(0, 0, 2))
+		return 1
+	case "weekdays", "weekday":
+		p.query.Day = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+		return 1
+	case "weekends":
+		p.query.Day = []time.Weekday{time.Saturday, time.Sunday}
+		return 1
+	case "this", "next", "on":
+		weeks := 0
+		if word == "next" {
+			weeks = 1
+		}
+		switch {
+		case next == "weekend":
+			// Days until Saturday, or back to Saturday if it is already Sunday
+			saturday := today.AddDate(0, 0, (int(time.Saturday)-int(today.Weekday())+7)%7)
+			if today.Weekday() == time.Sunday {
+				saturday = today.AddDate(0, 0, -1)
+			}
+			saturday = saturday.AddDate(0, 0, 7*weeks)
+			p.setDates(saturday, saturday.AddDate(0, 0, 2))
+			return 2
+		case next == "week":
+			monday := today.AddDate(0, 0, -((int(today.Weekday())+6)%7)).AddDate(0, 0, 7*weeks)
+			p.setDates(monday, monday.AddDate(0, 0, 7))
+			if weeks == 0 {
+				p.query.After = p.now
+			}
+			return 2
+		}
+		if day, ok := parseWeekday(next); ok {
+			p.setDay(day, word == "next")
+			return 2
+		}
+		return 0
+	case "weekend":
+		return p.matchDate("this", "weekend") - 1
+	}
+	if day, ok := parseWeekday(word); ok {
+		p.setDay(day, false)
+		return 1
+	}
+	// Plural days like "mondays" mean every monday
+	if day, ok := parseWeekday(strings.TrimSuffix(word, "s")); ok && strings.HasSuffix(word, "days") {
+		p.query.Day = append(p.query.Day, day)
+		return 1
+	}
+	return 0
+}
+
+// matchTime recognises times of day such as "after 5pm", "between 6 and 8am" and "evening"
+func (p *naturalParser) matchTime(words []string, i int) int {
+	word := words[i]
+	if window, ok := dayParts[word]; ok {
+		p.setTimes(window)
+		return 1
+	}
+	// A time on its own such as "6pm" is the same as "at 6pm", bare numbers are too ambiguous to use
+	if word[0] >= '0' && word[0] <= '9' {
+		t, n, ok := naturalClock(words[i:], "")
+		if ok && (strings.HasSuffix(words[i+n-1], "am") || strings.HasSuffix(words[i+n-1], "pm")) {
+			p.setTimes([2]TimeOfDay{t, hourAfter(t)})
+			return n
+		}
+	}
+	if i+1 >= len(words) {
+		return 0
+	}
+	if words[i+1] == "work" && (word == "before" || word == "after") {
+		if word == "before" {
+			p.setTimes([2]TimeOfDay{0, NewTimeOfDay(9, 0)})
+		} else {
+			p.setTimes([2]TimeOfDay{NewTimeOfDay(17, 0), 0})
+		}
+		return 2
+	}
+
+	switch word {
+	case "after", "from", "before", "until", "by", "at":
+		t, n, ok := naturalClock(words[i+1:], "")
+		if !ok {
+			return 0
+		}
+		switch word {
+		case "after", "from":
+			p.setTimes([2]TimeOfDay{t, 0})
+		case "at":
+			p.setTimes([2]TimeOfDay{t, hourAfter(t)})
+		default:
+			p.setTimes([2]TimeOfDay{0, t})
+		}
+		return n + 1
+	case "between":
+		// Find the end first so "between 6 and 8am" can use its am or pm for the start
+		for j := i + 2; j < len(words) && j <= i+3; j++ {
+			if words[j] != "and" {
+				continue
+			}
+			end, endWords, ok := naturalClock(words[j+1:], "")
+			if !ok {
+				return 0
+			}
+			meridiem := "am"
+			if end >= NewTimeOfDay(12, 0) {
+				meridiem = "pm"
+			}
+			start, _, ok := naturalClock(words[i+1:j], meridiem)
+			if !ok {
+				return 0
+			}
+			// "between 11 and 1pm" starts at 11am, so only use the end's am or pm if the start stays before it
+			if start >= end {
+				start, _, _ = naturalClock(words[i+1:j], "")
+			}
+			p.setTimes([2]TimeOfDay{start, end})
+			return j + 1 + endWords - i
+		}
+	}
+	return 0
+}
+
+// hourAfter returns the end of an hour long window starting at a time, which can't go past midnight
+func hourAfter(t TimeOfDay) TimeOfDay {
+	if t+60 > NewTimeOfDay(24, 0) {
+		return NewTimeOfDay(24, 0)
+	}
+	return t + 60
+}
+
+func (p *naturalParser) setDates(after time.Time, before time.Time) {
+	p.query.After = after
+	p.query.Before = before
+	p.dateSet = true
+}
+
+func (p *naturalParser) setTimes(window [2]TimeOfDay) {
+	p.query.FromTime = window[0]
+	p.query.ToTime = window[1]
+	p.timeSet = true
+}
+
+// setDay sets the dates to the next day of the week, including today unless strictlyAfter is set
+func (p *naturalParser) setDay(day time.Weekday, strictlyAfter bool) {
+	today := startOfDay(p.now)
+	days := (int(day) - int(today.Weekday()) + 7) % 7
+	if days == 0 && strictlyAfter {
+		days = 7
+	}
+	date := today.AddDate(0, 0, days)
+	p.setDates(date, date.AddDate(0, 0, 1))
+}
+
+// naturalClock parses a time such as "5pm", "5:30 pm", "17:00" or "noon" from the start of words and returns
+// how many words it used. Hours without am or pm use the meridiem provided, or are assumed to be in the
+// afternoon before 7 and in the morning otherwise
+func naturalClock(words []string, meridiem string) (TimeOfDay, int, bool) {
+	if len(words) == 0 {
+		return 0, 0, false
+	}
+	switch words[0] {
+	case "noon", "midday":
+		return NewTimeOfDay(12, 0), 1, true
+	case "midnight":
+		return NewTimeOfDay(24, 0), 1, true
+	}
+	word, used := words[0], 1
+	if len(words) > 1 && (words[1] == "am" || words[1] == "pm") {
+		word, used = word+words[1], 2
+	}
+	if strings.HasSuffix(word, "am") || strings.HasSuffix(word, "pm") {
+		meridiem, word = word[len(word)-2:], word[:len(word)-2]
+	}
+	parts := strings.Split(word, ":")
+	hour, err := strconv.Atoi(parts[0])
+	minute := 0
+	if err == nil && len(parts) == 2 {
+		minute, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || len(parts) > 2 || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	switch {
+	case meridiem == "pm" && hour < 12:
+		hour += 12
+	case meridiem == "am" && hour == 12:
+		hour = 0
+	case meridiem == "" && hour > 0 && hour < 7:
+		hour += 12
+	}
+	return NewTimeOfDay(hour, minute), used, true
+}
+
+// naturalWords lowercases a query and splits it into words, dropping punctuation
+func naturalWords(query string) []string {
+	query = strings.ToLower(query)
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == ':' || r == '\'')
+	})
+}
+
+// naturalClass returns the class name for a phrase that matches the catalogue or an alias
+func naturalClass(phrase string) string {
+	if alias, ok := ClassAliases[phrase]; ok {
+		return alias
+	}
+	squashed := strings.NewReplacer(" ", "", "'", "").Replace(phrase)
+	for _, c := range Classes {
+		name := strings.ToLower(c.Name)
+		if phrase == name || squashed == strings.NewReplacer(" ", "", "'", "").Replace(name) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// naturalGym returns the gym for a phrase that matches a gym's name or an alias
+func naturalGym(phrase string) Gym {
+	if alias, ok := GymAliases[phrase]; ok {
+		phrase = alias
+	}
+	for _, gym := range Gyms {
+		if phrase == gym.Name {
+			return gym
+		}
+	}
+	return Gym{}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,126 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type parseNaturalQueryTest struct {
+	query    string
+	expected GymQuery
+}
+
+func TestParseNaturalQuery(t *testing.T) {
+	// Tuesday morning in Auckland
+	now := time.Date(2018, 4, 3, 10, 0, 0, 0, gymLocation)
+	day := func(d int) time.Time { return time.Date(2018, 4, d, 0, 0, 0, 0, gymLocation) }
+	parseNaturalQueryTests := []parseNaturalQueryTest{
+		{
+			query:    "RPM at city tomorrow",
+			expected: GymQuery{Class: []string{"RPM"}, Gym: []Gym{GetGymByName("city")}, After: day(4), Before: day(5)},
+		},
+		{
+			query:    "any pump or body balance classes this weekend?",
+			expected: GymQuery{Class: []string{"BODYPUMP", "BODYBALANCE"}, After: day(7), Before: day(9)},
+		},
+		{
+			query:    "grit strength next Tuesday evening",
+			expected: GymQuery{Class: []string{"GRIT STRENGTH"}, After: day(10), Before: day(11), FromTime: NewTimeOfDay(17, 0)},
+		},
+		{
+			query:    "spin on friday between 6 and 8am",
+			expected: GymQuery{Class: []string{"RPM"}, After: day(6), Before: day(7), FromTime: NewTimeOfDay(6, 0), ToTime: NewTimeOfDay(8, 0)},
+		},
+		{
+			query:    "classes at takapuna after 5pm",
+			expected: GymQuery{Gym: []Gym{GetGymByName("takapuna")}, After: now, Before: day(4), FromTime: NewTimeOfDay(17, 0)},
+		},
+		{
+			query:    "any rpm before work on weekdays",
+			expected: GymQuery{Class: []string{"RPM"}, After: now, Before: now.AddDate(0, 0, 7), ToTime: NewTimeOfDay(9, 0), Day: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+		},
+		{
+			query:    "cardio tonight but not at north shore",
+			expected: GymQuery{Category: []string{CategoryCardio}, ExcludeGym: []Gym{GetGymByName("takapuna")}, After: day(3), Before: day(4), FromTime: NewTimeOfDay(17, 0)},
+		},
+		{
+			query:    "no yoga this week",
+			expected: GymQuery{ExcludeClass: []string{"YOGA"}, After: now, Before: day(9)},
+		},
+		{
+			query:    "no cardio rpm",
+			expected: GymQuery{Class: []string{"RPM"}, ExcludeClass: classesInCategory(CategoryCardio), After: now, Before: now.AddDate(0, 0, 7)},
+		},
+		{
+			query:    "not tomorrow rpm",
+			expected: GymQuery{Class: []string{"RPM"}, After: day(4), Before: day(5)},
+		},
+		{
+			query:    "rpm between 11 and 1pm",
+			expected: GymQuery{Class: []string{"RPM"}, After: now, Before: day(4), FromTime: NewTimeOfDay(11, 0), ToTime: NewTimeOfDay(13, 0)},
+		},
+		{
+			query:    "rpm at midnight",
+			expected: GymQuery{Class: []string{"RPM"}, After: now, Before: day(4), FromTime: NewTimeOfDay(24, 0), ToTime: NewTimeOfDay(24, 0)},
+		},
+		{
+			query:    "sh'bam at 6:30pm",
+			expected: GymQuery{Class: []string{"SH'BAM"}, After: now, Before: day(4), FromTime: NewTimeOfDay(18, 30), ToTime: NewTimeOfDay(19, 30)},
+		},
+		{
+			query:    "morning cxworx",
+			expected: GymQuery{Class: []string{"CXWORX"}, After: now, Before: day(4), FromTime: NewTimeOfDay(5, 0), ToTime: NewTimeOfDay(12, 0)},
+		},
+		{
+			query:    "lunchtime bodyattack",
+			expected: GymQuery{Class: []string{"BODYATTACK"}, After: now, Before: day(4), FromTime: NewTimeOfDay(11, 0), ToTime: NewTimeOfDay(14, 0)},
+		},
+		{
+			query:    "before 7am bodypump",
+			expected: GymQuery{Class: []string{"BODYPUMP"}, After: day(4), Before: day(5), ToTime: NewTimeOfDay(7, 0)},
+		},
+	}
+	for _, test := range parseNaturalQueryTests {
+		q, err := ParseNaturalQuery(test.query, now)
+		assert.NoError(t, err, "Failed to parse '%s'", test.query)
+		assert.Equal(t, FormatQuery(test.expected), FormatQuery(q), "Did not get the expected query for '%s'", test.query)
+	}
+
+	_, err := ParseNaturalQuery("what's on", now)
+	assert.Error(t, err, "Expected an error for a query without anything recognisable")
+}
+
+func TestParseNaturalQueryTimezone(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2018, 4, d, 0, 0, 0, 0, gymLocation) }
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skip("Timezone data is not available")
+	}
+	var tests = []struct {
+		now      time.Time
+		loc      *time.Location
+		expected GymQuery
+	}{
+		// Tuesday 11:30pm in Auckland is still Tuesday in UTC
+		{time.Date(2018, 4, 3, 11, 30, 0, 0, time.UTC), gymLocation, GymQuery{Class: []string{"RPM"}, After: day(3), Before: day(4)}},
+		// Wednesday 12:30am in Auckland is Tuesday in UTC
+		{time.Date(2018, 4, 3, 12, 30, 0, 0, time.UTC), gymLocation, GymQuery{Class: []string{"RPM"}, After: day(4), Before: day(5)}},
+		// Wednesday 12:30am in Auckland is Tuesday 10:30pm in Sydney
+		{time.Date(2018, 4, 3, 12, 30, 0, 0, time.UTC), sydney, GymQuery{Class: []string{"RPM"}, After: time.Date(2018, 4, 3, 0, 0, 0, 0, sydney), Before: time.Date(2018, 4, 4, 0, 0, 0, 0, sydney)}},
+	}
+	for _, test := range tests {
+		q, err := ParseNaturalQueryIn("rpm today", test.now, test.loc)
+		assert.NoError(t, err)
+		assert.True(t, test.expected.After.Equal(q.After), "%v: after %v", test.now, q.After)
+		assert.True(t, test.expected.Before.Equal(q.Before), "%v: before %v", test.now, q.Before)
+	}
+
+	// The configuration's clock and timezone are used by QueryClassesByName
+	c := &Config{Clock: func() time.Time { return time.Date(2018, 4, 3, 12, 30, 0, 0, time.UTC) }}
+	q, err := QueryClassesByName("rpm tonight", c)
+	assert.NoError(t, err)
+	assert.True(t, day(4).Equal(q.After), "after %v", q.After)
+	assert.True(t, day(5).Equal(q.Before), "before %v", q.Before)
+}
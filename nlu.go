@@ -0,0 +1,193 @@
+package lm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jsgoecke/go-wit"
+)
+
+// The types of DateTime found in a query
+const (
+	DateTimeValue    = "value"
+	DateTimeInterval = "interval"
+)
+
+// NLU understands natural language queries about gym classes. Relative dates such as "tomorrow" are worked out
+// from now, in now's timezone
+type NLU interface {
+	Understand(query string, now time.Time) (Intent, error)
+}
+
+// Intent describes the entities an NLU found in a query
+type Intent struct {
+	Classes  []string  `json:"classes"`
+	Gyms     []string  `json:"gyms"`
+	DateTime *DateTime `json:"datetime,omitempty"`
+}
+
+// DateTime describes a date found in a query. A value is a single time with a grain such as "day" or "week"
+// describing how much time it covers, and an interval has a start and end where either may be missing
+type DateTime struct {
+	Type  string    `json:"type"`
+	Value time.Time `json:"value"`
+	Grain string    `json:"grain"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+}
+
+// WitClient sends a message to wit.ai, it is satisfied by *wit.Client
+type WitClient interface {
+	Message(request *wit.MessageRequest) (*wit.Message, error)
+}
+
+// WitNLU understands queries using wit.ai
+type WitNLU struct {
+	Client WitClient
+}
+
+// StaticNLU returns a fixed Intent for each query, it is useful for testing
+type StaticNLU map[string]Intent
+
+// NewWitNLU returns an NLU that uses wit.ai with the access token provided
+func NewWitNLU(accessToken string) (*WitNLU, error) {
+	if accessToken == "" {
+		log.Error("Failed to get access token for wit.ai")
+		return nil, errors.New("No access token found for Wit.ai, please set the environment variable WIT_ACCESS_TOKEN")
+	}
+	return &WitNLU{Client: wit.NewClient(accessToken)}, nil
+}
+
+// Understand sends the query to wit.ai and returns the entities from the first outcome. now and its timezone
+// are sent as the context of the query so that wit.ai works out relative dates in the user's timezone
+func (w *WitNLU) Understand(query string, now time.Time) (Intent, error) {
+	log.Infof("Querying wit.ai for '%s'", query)
+	request := &wit.MessageRequest{}
+	request.Query = query
+	request.Context = wit.Context{ReferenceTime: now.Format(witTimeFormat), Timezone: now.Location().String()}
+	result, err := w.Client.Message(request)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to query wit.ai")
+		return Intent{}, errors.New("Failed to query wit.ai")
+	}
+	if len(result.Outcomes) == 0 {
+		log.Info("Failed to get a response from wit.ai")
+		return Intent{}, errors.New("Failed to find any classes")
+	}
+
+	var intent Intent
+	outcome := result.Outcomes[0]
+	for _, v := range outcome.Entities["gym_location"] {
+		intent.Gyms = append(intent.Gyms, witString(v.Value))
+	}
+	for _, v := range outcome.Entities["gym_classname"] {
+		intent.Classes = append(intent.Classes, witString(v.Value))
+	}
+	// We're only going to take the first datetime
+	if datetime := outcome.Entities["datetime"]; len(datetime) >= 1 {
+		d := datetime[0]
+		intent.DateTime = &DateTime{}
+		if d.Type != nil {
+			intent.DateTime.Type = *d.Type
+		}
+		if d.Grain != nil {
+			intent.DateTime.Grain = *d.Grain
+		}
+		intent.DateTime.Value = witTime(witString(d.Value))
+		if d.From != nil {
+			intent.DateTime.From = witTime(d.From.Value)
+		}
+		if d.To != nil {
+			intent.DateTime.To = witTime(d.To.Value)
+		}
+	}
+	return intent, nil
+}
+
+// Understand returns the Intent for the query or an error if there isn't one
+func (s StaticNLU) Understand(query string, now time.Time) (Intent, error) {
+	intent, ok := s[query]
+	if !ok {
+		return Intent{}, errors.New("Failed to find any classes")
+	}
+	return intent, nil
+}
+
+// Query converts the entities into a GymQuery, with any relative times worked out from now
+// A day covers the 24 hours from its value and a week the 7 days from its value. An interval without an end
+// covers a day from its start and anything else covers the 7 days from now
+func (i Intent) Query(now time.Time) GymQuery {
+	return i.QueryIn(now, gymLocation)
+}
+
+// QueryIn converts the entities into a GymQuery like Query, with the dates found in the query given in loc
+// It doesn't change which days the dates fall on, so the NLU must have worked them out in the same timezone
+func (i Intent) QueryIn(now time.Time, loc *time.Location) GymQuery {
+	var gymQuery GymQuery
+	gymQuery.Gym = []Gym{}
+	for _, name := range i.Gyms {
+		gym := GetGymByName(name)
+		if gym.Name != "" {
+			gymQuery.Gym = append(gymQuery.Gym, gym)
+		}
+	}
+	gymQuery.Class = []string{}
+	gymQuery.Class = append(gymQuery.Class, i.Classes...)
+
+	gymQuery.After = now
+	gymQuery.Before = now.AddDate(0, 0, 7)
+	var d *DateTime
+	if i.DateTime != nil {
+		d = &DateTime{
+			Type:  i.DateTime.Type,
+			Grain: i.DateTime.Grain,
+			Value: i.DateTime.Value.In(loc),
+			From:  i.DateTime.From.In(loc),
+			To:    i.DateTime.To.In(loc),
+		}
+	}
+	switch {
+	case d == nil:
+		log.Infof("Couldn't find a datetime so parsing as range %v to %v", gymQuery.After, gymQuery.Before)
+	case d.Type == DateTimeInterval:
+		if !d.From.IsZero() {
+			gymQuery.After = d.From
+		}
+		gymQuery.Before = gymQuery.After.AddDate(0, 0, 1)
+		if !d.To.IsZero() {
+			gymQuery.Before = d.To
+		}
+		log.Infof("Received a date interval parsing %v to %v as range %s to %s", d.From, d.To, gymQuery.After, gymQuery.Before)
+	case d.Type == DateTimeValue && !d.Value.IsZero() && d.Grain == "day":
+		gymQuery.After = d.Value
+		gymQuery.Before = d.Value.AddDate(0, 0, 1)
+	case d.Type == DateTimeValue && !d.Value.IsZero() && d.Grain == "week":
+		gymQuery.After = d.Value
+		gymQuery.Before = d.Value.AddDate(0, 0, 7)
+	}
+	if d != nil && d.Type == DateTimeValue {
+		log.Infof("Received a date with grain '%v' parsing %s as range %v to %v", d.Grain, d.Value, gymQuery.After, gymQuery.Before)
+	}
+	return gymQuery
+}
+
+func witString(v *interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", *v)
+}
+
+// witTimeFormat is the format of the times sent to and returned by wit.ai
+const witTimeFormat = "2006-01-02T15:04:05Z07:00"
+
+// witTime parses a time returned by wit.ai, returning the zero time if it can't be parsed
+func witTime(v string) time.Time {
+	t, err := time.Parse(witTimeFormat, v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,169 @@
+package lm
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jsgoecke/go-wit"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordedWit replays responses recorded from wit.ai
+type recordedWit map[string]string
+
+func (r recordedWit) Message(request *wit.MessageRequest) (*wit.Message, error) {
+	response, ok := r[request.Query]
+	if !ok {
+		return nil, errors.New("No recorded response")
+	}
+	var message wit.Message
+	err := json.Unmarshal([]byte(response), &message)
+	return &message, err
+}
+
+var witResponses = recordedWit{
+	"rpm at city tomorrow": `{"msg_id":"0Yb5mOvAvdMIJnEtN","_text":"rpm at city tomorrow","outcomes":[{"_text":"rpm at city tomorrow","intent":"default_intent","confidence":0.93,"entities":{
+		"gym_classname":[{"type":"value","value":"RPM"}],
+		"gym_location":[{"type":"value","value":"city"}],
+		"datetime":[{"type":"value","grain":"day","value":"2018-04-04T00:00:00.000+12:00"}]}}]}`,
+	"pump next week": `{"msg_id":"0ZE8HuSaLnpRuJcKy","_text":"pump next week","outcomes":[{"_text":"pump next week","intent":"default_intent","confidence":0.88,"entities":{
+		"gym_classname":[{"type":"value","value":"BODYPUMP"}],
+		"datetime":[{"type":"value","grain":"week","value":"2018-04-09T00:00:00.000+12:00"}]}}]}`,
+	"yoga at britomart this evening": `{"msg_id":"0nBCqdqjx4lVaHv4n","_text":"yoga at britomart this evening","outcomes":[{"_text":"yoga at britomart this evening","intent":"default_intent","confidence":0.9,"entities":{
+		"gym_classname":[{"type":"value","value":"LES MILLS YOGA"}],
+		"gym_location":[{"type":"value","value":"britomart"}],
+		"datetime":[{"type":"interval","from":{"value":"2018-04-03T18:00:00.000+12:00","grain":"hour"},"to":{"value":"2018-04-04T00:00:00.000+12:00","grain":"hour"}}]}}]}`,
+	"sprint from 6pm": `{"msg_id":"0mQ7wDxgpJ6hqqd9W","_text":"sprint from 6pm","outcomes":[{"_text":"sprint from 6pm","intent":"default_intent","confidence":0.9,"entities":{
+		"gym_classname":[{"type":"value","value":"SPRINT"}],
+		"datetime":[{"type":"interval","from":{"value":"2018-04-03T18:00:00.000+12:00","grain":"hour"}}]}}]}`,
+	"grit at takapuna": `{"msg_id":"0cX3tz9fY1tCwhkfe","_text":"grit at takapuna","outcomes":[{"_text":"grit at takapuna","intent":"default_intent","confidence":0.95,"entities":{
+		"gym_classname":[{"type":"value","value":"GRIT"}],
+		"gym_location":[{"type":"value","value":"takapuna"}]}}]}`,
+	"hello": `{"msg_id":"0Tb7cDBNWxBjsnLPu","_text":"hello","outcomes":[]}`,
+}
+
+func TestWitNLU(t *testing.T) {
+	nz := time.FixedZone("NZST", 12*60*60)
+	now := time.Date(2018, 4, 3, 10, 0, 0, 0, nz)
+	nlu := &WitNLU{Client: witResponses}
+	var tests = []struct {
+		query    string
+		expected Intent
+		err      bool
+	}{
+		{"rpm at city tomorrow", Intent{Classes: []string{"RPM"}, Gyms: []string{"city"}, DateTime: &DateTime{Type: DateTimeValue, Grain: "day", Value: time.Date(2018, 4, 4, 0, 0, 0, 0, nz)}}, false},
+		{"yoga at britomart this evening", Intent{Classes: []string{"LES MILLS YOGA"}, Gyms: []string{"britomart"}, DateTime: &DateTime{Type: DateTimeInterval, From: time.Date(2018, 4, 3, 18, 0, 0, 0, nz), To: time.Date(2018, 4, 4, 0, 0, 0, 0, nz)}}, false},
+		{"grit at takapuna", Intent{Classes: []string{"GRIT"}, Gyms: []string{"takapuna"}}, false},
+		{"hello", Intent{}, true},
+		{"not recorded", Intent{}, true},
+	}
+	for _, test := range tests {
+		actual, err := nlu.Understand(test.query, now)
+		if test.err {
+			assert.Error(t, err, test.query)
+			continue
+		}
+		assert.NoError(t, err, test.query)
+		assert.Equal(t, test.expected.Classes, actual.Classes, test.query)
+		assert.Equal(t, test.expected.Gyms, actual.Gyms, test.query)
+		if test.expected.DateTime == nil {
+			assert.Nil(t, actual.DateTime, test.query)
+			continue
+		}
+		assert.Equal(t, test.expected.DateTime.Type, actual.DateTime.Type, test.query)
+		assert.Equal(t, test.expected.DateTime.Grain, actual.DateTime.Grain, test.query)
+		assert.True(t, test.expected.DateTime.Value.Equal(actual.DateTime.Value), test.query)
+		assert.True(t, test.expected.DateTime.From.Equal(actual.DateTime.From), test.query)
+		assert.True(t, test.expected.DateTime.To.Equal(actual.DateTime.To), test.query)
+	}
+}
+
+func TestIntentQuery(t *testing.T) {
+	nz := time.FixedZone("NZST", 12*60*60)
+	now := time.Date(2018, 4, 3, 10, 0, 0, 0, nz)
+	nlu := &WitNLU{Client: witResponses}
+	var tests = []struct {
+		query  string
+		gyms   []string
+		after  time.Time
+		before time.Time
+	}{
+		// grain day covers the whole day
+		{"rpm at city tomorrow", []string{"city"}, time.Date(2018, 4, 4, 0, 0, 0, 0, nz), time.Date(2018, 4, 5, 0, 0, 0, 0, nz)},
+		// grain week covers the seven days from the start of the week
+		{"pump next week", []string{}, time.Date(2018, 4, 9, 0, 0, 0, 0, nz), time.Date(2018, 4, 16, 0, 0, 0, 0, nz)},
+		// an interval covers its start to its end
+		{"yoga at britomart this evening", []string{"britomart"}, time.Date(2018, 4, 3, 18, 0, 0, 0, nz), time.Date(2018, 4, 4, 0, 0, 0, 0, nz)},
+		// an interval without an end covers a day
+		{"sprint from 6pm", []string{}, time.Date(2018, 4, 3, 18, 0, 0, 0, nz), time.Date(2018, 4, 4, 18, 0, 0, 0, nz)},
+		// no datetime covers the next seven days
+		{"grit at takapuna", []string{"takapuna"}, now, now.AddDate(0, 0, 7)},
+	}
+	for _, test := range tests {
+		intent, err := nlu.Understand(test.query, now)
+		assert.NoError(t, err, test.query)
+		query := intent.Query(now)
+		assert.Equal(t, intent.Classes, query.Class, test.query)
+		var gyms = []string{}
+		for _, g := range query.Gym {
+			gyms = append(gyms, g.Name)
+		}
+		assert.Equal(t, test.gyms, gyms, test.query)
+		assert.True(t, test.after.Equal(query.After), "%s: after %v", test.query, query.After)
+		assert.True(t, test.before.Equal(query.Before), "%s: before %v", test.query, query.Before)
+	}
+}
+
+func TestQueryClassesByNameNLU(t *testing.T) {
+	now := time.Now().In(gymLocation)
+	c := &Config{NLU: StaticNLU{
+		"rpm": Intent{Classes: []string{"RPM"}, DateTime: &DateTime{Type: DateTimeValue, Grain: "day", Value: now}},
+	}}
+	query, err := QueryClassesByName("rpm", c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"RPM"}, query.Class)
+	assert.True(t, now.Equal(query.After))
+	assert.True(t, now.AddDate(0, 0, 1).Equal(query.Before))
+
+	_, err = QueryClassesByName("unknown", c)
+	assert.Error(t, err)
+}
+
+// witTomorrow works out "tomorrow" like wit.ai does, from the reference time in the context of the request
+type witTomorrow struct {
+	requests []*wit.MessageRequest
+}
+
+func (w *witTomorrow) Message(request *wit.MessageRequest) (*wit.Message, error) {
+	w.requests = append(w.requests, request)
+	reference, err := time.Parse(witTimeFormat, request.Context.ReferenceTime)
+	if err != nil {
+		return nil, err
+	}
+	tomorrow := time.Date(reference.Year(), reference.Month(), reference.Day()+1, 0, 0, 0, 0, reference.Location())
+	response := `{"outcomes":[{"_text":"rpm tomorrow","entities":{
+		"gym_classname":[{"type":"value","value":"RPM"}],
+		"datetime":[{"type":"value","grain":"day","value":"` + tomorrow.Format(witTimeFormat) + `"}]}}]}`
+	var message wit.Message
+	err = json.Unmarshal([]byte(response), &message)
+	return &message, err
+}
+
+func TestIntentQueryIn(t *testing.T) {
+	// It is still Tuesday in UTC but already Wednesday in Auckland
+	now := time.Date(2018, 4, 3, 12, 30, 0, 0, time.UTC).In(gymLocation)
+	client := &witTomorrow{}
+	nlu := &WitNLU{Client: client}
+	intent, err := nlu.Understand("rpm tomorrow", now)
+	assert.NoError(t, err)
+	if assert.Len(t, client.requests, 1) {
+		assert.Equal(t, "2018-04-04T00:30:00+12:00", client.requests[0].Context.ReferenceTime)
+		assert.Equal(t, TimeZone, client.requests[0].Context.Timezone)
+	}
+	query := intent.QueryIn(now, gymLocation)
+	assert.True(t, time.Date(2018, 4, 5, 0, 0, 0, 0, gymLocation).Equal(query.After), "after %v", query.After)
+	assert.True(t, time.Date(2018, 4, 6, 0, 0, 0, 0, gymLocation).Equal(query.Before), "before %v", query.Before)
+	assert.Equal(t, gymLocation, query.After.Location())
+}
@@ -0,0 +1,216 @@
+package lm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The keys that classes can be sorted by
+const (
+	SortStartTime = "start"
+	SortGym       = "gym"
+	SortName      = "name"
+	SortDuration  = "duration"
+	SortDistance  = "distance"
+)
+
+// Coordinates describes a location by its latitude and longitude
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ClassPage describes a single page of classes returned from a query
+type ClassPage struct {
+	Classes    GymClasses `json:"classes"`
+	Total      int        `json:"total"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// GymCoordinates provides the location of each gym by name
+var GymCoordinates = map[string]Coordinates{
+	"city":      {-36.8485, 174.7578},
+	"britomart": {-36.8440, 174.7685},
+	"takapuna":  {-36.7886, 174.7715},
+	"newmarket": {-36.8697, 174.7779},
+}
+
+// pageCursor describes the last class of a page, which the next page starts after
+type pageCursor struct {
+	SortBy        string      `json:"s"`
+	Descending    bool        `json:"d"`
+	Near          Coordinates `json:"nr"`
+	UUID          string      `json:"u"`
+	Gym           string      `json:"g"`
+	Name          string      `json:"n"`
+	StartDateTime time.Time   `json:"st"`
+	EndDateTime   time.Time   `json:"et"`
+}
+
+// IsZero reports whether no coordinates have been provided
+func (c Coordinates) IsZero() bool {
+	return c.Latitude == 0 && c.Longitude == 0
+}
+
+// DistanceTo returns the distance in kilometres between two coordinates
+func (c Coordinates) DistanceTo(o Coordinates) float64 {
+	const earthRadius = 6371.0
+	lat1 := c.Latitude * math.Pi / 180
+	lat2 := o.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (o.Longitude - c.Longitude) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Distance returns the distance in kilometres from the class's gym to a location or positive infinity if the
+// gym's location isn't known
+func (g GymClass) Distance(from Coordinates) float64 {
+	c, ok := GymCoordinates[g.Gym]
+	if !ok {
+		return math.Inf(1)
+	}
+	return from.DistanceTo(c)
+}
+
+// classLess returns a function that orders classes by the key provided. Classes with the same key are ordered
+// by their start time and then their UUID so that the order is always the same
+func classLess(sortBy string, descending bool, near Coordinates) (func(a, b GymClass) bool, error) {
+	var compare func(a, b GymClass) int
+	switch sortBy {
+	case "", SortStartTime:
+		compare = func(a, b GymClass) int { return 0 }
+	case SortGym:
+		compare = func(a, b GymClass) int { return strings.Compare(a.Gym, b.Gym) }
+	case SortName:
+		compare = func(a, b GymClass) int { return strings.Compare(a.Name, b.Name) }
+	case SortDuration:
+		compare = func(a, b GymClass) int { return compareDuration(a.Duration(), b.Duration()) }
+	case SortDistance:
+		if near.IsZero() {
+			return nil, errors.New("Sorting by distance requires a location to measure from")
+		}
+		compare = func(a, b GymClass) int { return compareFloat(a.Distance(near), b.Distance(near)) }
+	default:
+		return nil, errors.New("Unknown sort key '" + sortBy + "'")
+	}
+	return func(a, b GymClass) bool {
+		c := compare(a, b)
+		if c == 0 {
+			c = compareTime(a.StartDateTime, b.StartDateTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.UUID, b.UUID)
+		}
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	}, nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareDuration(a, b time.Duration) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// paginate sorts the classes and returns the page described by the query
+func paginate(classes GymClasses, query GymQuery) (ClassPage, error) {
+	less, err := classLess(query.SortBy, query.Descending, query.Near)
+	if err != nil {
+		return ClassPage{}, err
+	}
+	sort.Slice(classes, func(i, j int) bool { return less(classes[i], classes[j]) })
+	page := ClassPage{Total: len(classes)}
+
+	start := 0
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return ClassPage{}, err
+		}
+		if cursor.SortBy != query.SortBy || cursor.Descending != query.Descending || cursor.Near != query.Near {
+			return ClassPage{}, errors.New("Cursor was created for a different sort order")
+		}
+		last := GymClass{
+			UUID:          cursor.UUID,
+			Gym:           cursor.Gym,
+			Name:          cursor.Name,
+			StartDateTime: cursor.StartDateTime,
+			EndDateTime:   cursor.EndDateTime,
+		}
+		start = sort.Search(len(classes), func(i int) bool { return less(last, classes[i]) })
+	}
+	if query.Offset > 0 {
+		start += query.Offset
+	}
+	if start > len(classes) {
+		start = len(classes)
+	}
+	end := len(classes)
+	if query.Limit > 0 && start+query.Limit < end {
+		end = start + query.Limit
+	}
+
+	page.Classes = classes[start:end]
+	if end < len(classes) && end > start {
+		page.NextCursor = encodeCursor(classes[end-1], query)
+	}
+	return page, nil
+}
+
+func encodeCursor(last GymClass, query GymQuery) string {
+	b, _ := json.Marshal(pageCursor{
+		SortBy:        query.SortBy,
+		Descending:    query.Descending,
+		Near:          query.Near,
+		UUID:          last.UUID,
+		Gym:           last.Gym,
+		Name:          last.Name,
+		StartDateTime: last.StartDateTime,
+		EndDateTime:   last.EndDateTime,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return pageCursor{}, errors.New("Invalid cursor")
+	}
+	return c, nil
+}
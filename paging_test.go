@@ -0,0 +1,148 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type paginateTest struct {
+	name     string
+	query    GymQuery
+	expected []string
+	err      bool
+}
+
+func classUUIDs(classes GymClasses) []string {
+	uuids := []string{}
+	for _, c := range classes {
+		uuids = append(uuids, c.UUID)
+	}
+	return uuids
+}
+
+func TestPaginate(t *testing.T) {
+	uuid := func(i int) string { return testClasses[i].UUID }
+	paginateTests := []paginateTest{
+		{
+			name:     "Start time",
+			query:    GymQuery{},
+			expected: []string{uuid(0), uuid(1), uuid(5), uuid(2), uuid(3), uuid(4)},
+		},
+		{
+			name:     "Start time descending",
+			query:    GymQuery{Descending: true},
+			expected: []string{uuid(4), uuid(3), uuid(2), uuid(5), uuid(1), uuid(0)},
+		},
+		{
+			name:     "Gym",
+			query:    GymQuery{SortBy: SortGym},
+			expected: []string{uuid(5), uuid(0), uuid(1), uuid(2), uuid(3), uuid(4)},
+		},
+		{
+			name:     "Name with limit and offset",
+			query:    GymQuery{SortBy: SortName, Limit: 2, Offset: 1},
+			expected: []string{uuid(0), uuid(4)},
+		},
+		{
+			name:     "Distance from Britomart",
+			query:    GymQuery{SortBy: SortDistance, Near: GymCoordinates["britomart"], Limit: 1},
+			expected: []string{uuid(5)},
+		},
+		{
+			name:  "Distance without location",
+			query: GymQuery{SortBy: SortDistance},
+			err:   true,
+		},
+		{
+			name:  "Unknown sort",
+			query: GymQuery{SortBy: "instructor"},
+			err:   true,
+		},
+		{
+			name:  "Invalid cursor",
+			query: GymQuery{Cursor: "not a cursor"},
+			err:   true,
+		},
+	}
+	for _, test := range paginateTests {
+		classes := make(GymClasses, len(testClasses))
+		copy(classes, testClasses)
+		page, err := paginate(classes, test.query)
+		if test.err {
+			assert.Error(t, err, "Failed %s test - Expected an error", test.name)
+			continue
+		}
+		assert.NoError(t, err, "Failed %s test", test.name)
+		assert.Equal(t, test.expected, classUUIDs(page.Classes), "Failed %s test - Classes were not in the expected order", test.name)
+		assert.Equal(t, len(testClasses), page.Total, "Failed %s test - Did not get the total number of classes", test.name)
+	}
+}
+
+func TestPaginateNanoseconds(t *testing.T) {
+	// Start times a nanosecond apart are ordered by time rather than falling back to the UUID
+	start := time.Date(2018, 4, 3, 6, 0, 0, 0, time.UTC)
+	classes := GymClasses{
+		{UUID: "a", Gym: "city", Name: "RPM", StartDateTime: start.Add(time.Nanosecond), EndDateTime: start.Add(time.Hour)},
+		{UUID: "b", Gym: "city", Name: "RPM", StartDateTime: start, EndDateTime: start.Add(time.Hour)},
+	}
+	page, err := paginate(classes, GymQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, classUUIDs(page.Classes))
+}
+
+func TestPaginateCursorNear(t *testing.T) {
+	query := GymQuery{SortBy: SortDistance, Near: GymCoordinates["britomart"], Limit: 2}
+	classes := make(GymClasses, len(testClasses))
+	copy(classes, testClasses)
+	first, err := paginate(classes, query)
+	if !assert.NoError(t, err) || !assert.NotEmpty(t, first.NextCursor) {
+		return
+	}
+
+	query.Cursor = first.NextCursor
+	_, err = paginate(classes, query)
+	assert.NoError(t, err, "The cursor should work with the same location")
+	query.Near = GymCoordinates["takapuna"]
+	_, err = paginate(classes, query)
+	assert.Error(t, err, "Expected an error for a cursor created for a different location")
+}
+
+func TestQueryClassesPageCursor(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+	_, err = StoreClasses(testClasses, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes %s", err)
+	}
+
+	all, err := QueryClasses(GymQuery{SortBy: SortName}, testConfig)
+	if err != nil {
+		t.Errorf("Failed to query classes %s", err)
+	}
+
+	// Walking through every page should return every class once in the same order
+	query := GymQuery{SortBy: SortName, Limit: 4}
+	var paged GymClasses
+	for pages := 0; pages < len(testClasses); pages++ {
+		page, err := QueryClassesPage(query, testConfig)
+		if err != nil {
+			t.Errorf("Failed to query page of classes %s", err)
+			break
+		}
+		paged = append(paged, page.Classes...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	assert.Equal(t, classUUIDs(all), classUUIDs(paged), "Paging through classes did not return every class")
+}
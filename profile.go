@@ -0,0 +1,165 @@
+package lm
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// UserProfile holds the preferences a user has set themselves, as opposed to UserPreference which is learned
+// from the classes they have been to. PreferredFrom and PreferredTo are a window of local time like the ones in
+// GymQuery, and Timezone is an IANA timezone such as "Pacific/Auckland"
+type UserProfile struct {
+	UserID           string         `json:"userID" db:"user_id" storm:"id"`
+	HomeGym          string         `json:"homeGym" db:"home_gym"`
+	OtherGyms        []string       `json:"otherGyms" db:"other_gyms"`
+	Timezone         string         `json:"timezone" db:"timezone"`
+	PreferredClasses []string       `json:"preferredClasses" db:"preferred_classes"`
+	PreferredDays    []time.Weekday `json:"preferredDays" db:"preferred_days"`
+	PreferredFrom    TimeOfDay      `json:"preferredFrom" db:"preferred_from"`
+	PreferredTo      TimeOfDay      `json:"preferredTo" db:"preferred_to"`
+	BlockedClasses   []string       `json:"blockedClasses" db:"blocked_classes"`
+	Updated          time.Time      `json:"updated" db:"updated"`
+}
+
+// StoreUserProfile stores a user's profile, replacing any profile they already have
+func StoreUserProfile(profile UserProfile, dbConfig *Config) error {
+	err := validateUserProfile(profile)
+	if err != nil {
+		return err
+	}
+	profile.Updated = dbConfig.now()
+	err = dbConfig.DB.Save(&profile)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": profile.UserID}).Error("Failed to store user profile")
+		return err
+	}
+	log.Infof("Stored profile for user %s", profile.UserID)
+	return nil
+}
+
+// GetUserProfile returns a user's profile, users who haven't stored a profile get an empty one
+func GetUserProfile(user string, dbConfig *Config) (UserProfile, error) {
+	var profile UserProfile
+	err := dbConfig.DB.One("UserID", user, &profile)
+	if err == storm.ErrNotFound {
+		return UserProfile{UserID: user}, nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to get user profile")
+		return UserProfile{}, err
+	}
+	return profile, nil
+}
+
+// Location returns the profile's timezone, or nil if it doesn't have one
+func (p UserProfile) Location() *time.Location {
+	if p.Timezone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": p.UserID}).Warn("Ignoring invalid timezone in user profile")
+		return nil
+	}
+	return loc
+}
+
+// mergedPreferences combines the preferences a user has set with the ones learned from their history
+type mergedPreferences struct {
+	classes []string
+	gyms    []Gym
+	blocked []string
+	days    []time.Weekday
+	from    TimeOfDay
+	to      TimeOfDay
+}
+
+// mergePreferences combines a profile with learned preferences. Preferred classes and gyms from both are used,
+// while the profile's preferred times replace the learned preferred time. Blocked classes are never preferred
+func mergePreferences(profile UserProfile, learned UserPreference) mergedPreferences {
+	var m mergedPreferences
+	m.blocked = profile.BlockedClasses
+	m.days = profile.PreferredDays
+
+	addClass := func(name string) {
+		if name == "" || containsFold(m.classes, name) || containsFold(m.blocked, name) {
+			return
+		}
+		m.classes = append(m.classes, name)
+	}
+	for _, c := range profile.PreferredClasses {
+		addClass(c)
+	}
+	addClass(learned.PreferredClass)
+
+	addGym := func(name string) {
+		gym := GetGymByName(strings.ToLower(name))
+		if gym.Name == "" {
+			return
+		}
+		for _, g := range m.gyms {
+			if g.Name == gym.Name {
+				return
+			}
+		}
+		m.gyms = append(m.gyms, gym)
+	}
+	addGym(profile.HomeGym)
+	for _, g := range profile.OtherGyms {
+		addGym(g)
+	}
+	addGym(learned.PreferredGym)
+
+	switch {
+	case profile.PreferredFrom != 0 || profile.PreferredTo != 0:
+		m.from, m.to = profile.PreferredFrom, profile.PreferredTo
+	case learned.PreferredClass != "" || learned.PreferredGym != "":
+		// The learned time is only meaningful when the user has been to a class
+		m.from = NewTimeOfDay(learned.PreferredTime-1, 0)
+		if m.from < 0 {
+			m.from = 0
+		}
+		m.to = NewTimeOfDay(learned.PreferredTime+1, 0)
+	}
+	return m
+}
+
+// validateUserProfile checks the profile belongs to a user and only refers to gyms and timezones that exist
+func validateUserProfile(profile UserProfile) error {
+	if profile.UserID == "" {
+		return errors.New("User profile must have a user")
+	}
+	for _, name := range append([]string{profile.HomeGym}, profile.OtherGyms...) {
+		if name != "" && GetGymByName(strings.ToLower(name)).Name == "" {
+			return errors.New("Unknown gym " + name)
+		}
+	}
+	if profile.Timezone != "" {
+		_, err := time.LoadLocation(profile.Timezone)
+		if err != nil {
+			return errors.New("Unknown timezone " + profile.Timezone)
+		}
+	}
+	for _, d := range profile.PreferredDays {
+		if d < time.Sunday || d > time.Saturday {
+			return errors.New("Invalid preferred day")
+		}
+	}
+	day := NewTimeOfDay(24, 0)
+	if profile.PreferredFrom < 0 || profile.PreferredFrom >= day || profile.PreferredTo < 0 || profile.PreferredTo >= day {
+		return errors.New("Preferred times must be within a day")
+	}
+	return nil
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
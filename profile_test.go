@@ -0,0 +1,79 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePreferences(t *testing.T) {
+	var tests = []struct {
+		name     string
+		profile  UserProfile
+		learned  UserPreference
+		expected mergedPreferences
+	}{
+		{
+			name:     "No profile or history",
+			expected: mergedPreferences{},
+		},
+		{
+			name:     "Learned preferences only",
+			learned:  UserPreference{PreferredClass: "RPM", PreferredGym: "city", PreferredTime: 7},
+			expected: mergedPreferences{classes: []string{"RPM"}, gyms: []Gym{GetGymByName("city")}, from: NewTimeOfDay(6, 0), to: NewTimeOfDay(8, 0)},
+		},
+		{
+			name:     "Profile only",
+			profile:  UserProfile{HomeGym: "Takapuna", OtherGyms: []string{"city"}, PreferredClasses: []string{"GRIT"}, PreferredDays: []time.Weekday{time.Saturday}, PreferredFrom: NewTimeOfDay(9, 0)},
+			expected: mergedPreferences{classes: []string{"GRIT"}, gyms: []Gym{GetGymByName("takapuna"), GetGymByName("city")}, days: []time.Weekday{time.Saturday}, from: NewTimeOfDay(9, 0)},
+		},
+		{
+			name:     "Profile times replace the learned time and duplicates are removed",
+			profile:  UserProfile{HomeGym: "city", PreferredClasses: []string{"rpm", "SPRINT"}, PreferredFrom: NewTimeOfDay(17, 0), PreferredTo: NewTimeOfDay(19, 0)},
+			learned:  UserPreference{PreferredClass: "RPM", PreferredGym: "city", PreferredTime: 7},
+			expected: mergedPreferences{classes: []string{"rpm", "SPRINT"}, gyms: []Gym{GetGymByName("city")}, from: NewTimeOfDay(17, 0), to: NewTimeOfDay(19, 0)},
+		},
+		{
+			name:     "Blocked classes are never preferred",
+			profile:  UserProfile{PreferredClasses: []string{"BODYJAM"}, BlockedClasses: []string{"rpm", "bodyjam"}},
+			learned:  UserPreference{PreferredClass: "RPM", PreferredGym: "britomart", PreferredTime: 0},
+			expected: mergedPreferences{gyms: []Gym{GetGymByName("britomart")}, blocked: []string{"rpm", "bodyjam"}, from: 0, to: NewTimeOfDay(1, 0)},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, mergePreferences(test.profile, test.learned), test.name)
+	}
+}
+
+func TestStoreUserProfile(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+
+	profile, err := GetUserProfile("123", testConfig)
+	assert.NoError(t, err, "Users without a profile should get an empty one")
+	assert.Equal(t, UserProfile{UserID: "123"}, profile)
+
+	assert.Error(t, StoreUserProfile(UserProfile{HomeGym: "city"}, testConfig), "Expected an error without a user")
+	assert.Error(t, StoreUserProfile(UserProfile{UserID: "123", HomeGym: "wellington"}, testConfig), "Expected an error for an unknown gym")
+	assert.Error(t, StoreUserProfile(UserProfile{UserID: "123", Timezone: "Middle/Earth"}, testConfig), "Expected an error for an unknown timezone")
+	assert.Error(t, StoreUserProfile(UserProfile{UserID: "123", PreferredTo: NewTimeOfDay(25, 0)}, testConfig), "Expected an error for a time outside a day")
+
+	stored := UserProfile{UserID: "123", HomeGym: "city", Timezone: "Pacific/Auckland", PreferredClasses: []string{"RPM"}, PreferredDays: []time.Weekday{time.Monday}}
+	assert.NoError(t, StoreUserProfile(stored, testConfig))
+	profile, err = GetUserProfile("123", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.HomeGym, profile.HomeGym)
+	assert.Equal(t, stored.PreferredClasses, profile.PreferredClasses)
+	assert.Equal(t, stored.PreferredDays, profile.PreferredDays)
+	assert.False(t, profile.Updated.IsZero())
+	assert.NotNil(t, profile.Location())
+}
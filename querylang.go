@@ -0,0 +1,511 @@
+package lm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The filters understood by ParseQuery, a filter can be negated by prefixing it with - where noted
+//
+//	class:rpm,bodypump      classes with a name containing any of the values, negatable
+//	gym:city,britomart      classes at any of the gyms, negatable
+//	studio:"RPM Studio"     classes in any of the studios
+//	category:cardio         classes in any of the categories
+//	day:mon-fri,sun         classes on any of the days or ranges of days
+//	time:06:00-08:00        classes starting within the local time window, either end can be left out
+//	duration:45m-1h         classes running for between the durations, either end can be left out
+//	after:2018-04-01        classes starting after the date or date and time (2018-04-01T06:00)
+//	before:2018-04-08       classes starting before the date or date and time
+//	after:today before:+7d  dates can also be now, today, tomorrow or a number of hours, days or weeks from now
+//	near:-36.85,174.76      the latitude and longitude that distances are measured from
+//	sort:-start             sort by start, gym, name, duration or distance, descending when prefixed with -
+//	limit:20 offset:40      page through the results
+//
+// A term without a filter is treated as a class name, so "rpm -yoga" is the same as "class:rpm -class:yoga"
+const (
+	filterClass    = "class"
+	filterGym      = "gym"
+	filterStudio   = "studio"
+	filterCategory = "category"
+	filterDay      = "day"
+	filterTime     = "time"
+	filterDuration = "duration"
+	filterAfter    = "after"
+	filterBefore   = "before"
+	filterNear     = "near"
+	filterSort     = "sort"
+	filterLimit    = "limit"
+	filterOffset   = "offset"
+)
+
+var queryFilters = []string{filterClass, filterGym, filterStudio, filterCategory, filterDay, filterTime, filterDuration, filterAfter, filterBefore, filterNear, filterSort, filterLimit, filterOffset}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+const (
+	queryDateFormat            = "2006-01-02"
+	queryDateTimeFormat        = "2006-01-02T15:04"
+	queryDateTimeSecondsFormat = "2006-01-02T15:04:05.999999999"
+)
+
+// QueryParseError describes a term in a query that couldn't be parsed
+type QueryParseError struct {
+	Position int
+	Term     string
+	Message  string
+}
+
+func (e *QueryParseError) Error() string {
+	return fmt.Sprintf("Invalid query term '%s' at position %d: %s", e.Term, e.Position, e.Message)
+}
+
+// queryTerm is a single whitespace separated part of a query
+type queryTerm struct {
+	text     string
+	position int
+}
+
+// ParseQuery parses a query written in the compact query language into a GymQuery
+// Relative dates are worked out from the current time in the gyms' timezone
+func ParseQuery(query string) (GymQuery, error) {
+	return ParseQueryAt(query, localTime(time.Now()))
+}
+
+// ParseQueryAt parses a query like ParseQuery, working out relative dates such as today and +7d from now
+// Today and tomorrow start at midnight in now's timezone
+func ParseQueryAt(query string, now time.Time) (GymQuery, error) {
+	var q GymQuery
+	terms, err := splitQuery(query)
+	if err != nil {
+		return GymQuery{}, err
+	}
+	for _, term := range terms {
+		err = parseQueryTerm(&q, term, now)
+		if err != nil {
+			return GymQuery{}, err
+		}
+	}
+	return q, nil
+}
+
+// FormatQuery writes a GymQuery in the compact query language, so that ParseQuery returns the same query
+func FormatQuery(q GymQuery) string {
+	var terms []string
+	add := func(filter string, values ...string) {
+		if len(values) == 0 {
+			return
+		}
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = quoteQueryValue(v)
+		}
+		terms = append(terms, filter+":"+strings.Join(quoted, ","))
+	}
+	// Values written by the formatter itself never need quoting
+	addRaw := func(filter string, value string) {
+		terms = append(terms, filter+":"+value)
+	}
+
+	add(filterClass, q.Class...)
+	add("-"+filterClass, q.ExcludeClass...)
+	add(filterGym, gymNames(q.Gym)...)
+	add("-"+filterGym, gymNames(q.ExcludeGym)...)
+	add(filterStudio, q.Location...)
+	add(filterCategory, q.Category...)
+	if len(q.Day) > 0 {
+		addRaw(filterDay, formatDays(q.Day))
+	}
+	if q.FromTime != 0 || q.ToTime != 0 {
+		window := q.FromTime.String() + "-"
+		if q.ToTime != 0 {
+			window += q.ToTime.String()
+		}
+		addRaw(filterTime, window)
+	}
+	if q.MinDuration != 0 || q.MaxDuration != 0 {
+		addRaw(filterDuration, formatQueryDuration(q.MinDuration)+"-"+formatQueryDuration(q.MaxDuration))
+	}
+	if !q.After.IsZero() {
+		addRaw(filterAfter, formatQueryTime(q.After))
+	}
+	if !q.Before.IsZero() {
+		addRaw(filterBefore, formatQueryTime(q.Before))
+	}
+	if !q.Near.IsZero() {
+		addRaw(filterNear, formatQueryFloat(q.Near.Latitude)+","+formatQueryFloat(q.Near.Longitude))
+	}
+	if q.SortBy != "" || q.Descending {
+		sortBy := q.SortBy
+		if sortBy == "" {
+			sortBy = SortStartTime
+		}
+		if q.Descending {
+			sortBy = "-" + sortBy
+		}
+		addRaw(filterSort, sortBy)
+	}
+	if q.Limit != 0 {
+		addRaw(filterLimit, strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		addRaw(filterOffset, strconv.Itoa(q.Offset))
+	}
+	return strings.Join(terms, " ")
+}
+
+func parseQueryTerm(q *GymQuery, term queryTerm, now time.Time) error {
+	fail := func(format string, args ...interface{}) error {
+		return &QueryParseError{Position: term.position, Term: term.text, Message: fmt.Sprintf(format, args...)}
+	}
+
+	text := term.text
+	negated := strings.HasPrefix(text, "-")
+	if negated {
+		text = text[1:]
+	}
+	filter, value := filterClass, text
+	if i := strings.Index(text, ":"); i >= 0 && !strings.HasPrefix(text, `"`) {
+		filter, value = strings.ToLower(text[:i]), text[i+1:]
+	}
+	if value == "" {
+		return fail("missing a value")
+	}
+	if negated && filter != filterClass && filter != filterGym {
+		return fail("only class and gym filters can be excluded")
+	}
+	values := splitQueryValues(value)
+
+	switch filter {
+	case filterClass:
+		if negated {
+			q.ExcludeClass = append(q.ExcludeClass, values...)
+		} else {
+			q.Class = append(q.Class, values...)
+		}
+	case filterGym:
+		for _, v := range values {
+			gym := GetGymByName(strings.ToLower(v))
+			if gym.Name == "" {
+				return fail("unknown gym '%s', expected one of %s", v, strings.Join(gymNames(Gyms), ", "))
+			}
+			if negated {
+				q.ExcludeGym = append(q.ExcludeGym, gym)
+			} else {
+				q.Gym = append(q.Gym, gym)
+			}
+		}
+	case filterStudio:
+		q.Location = append(q.Location, values...)
+	case filterCategory:
+		for _, v := range values {
+			category := strings.ToLower(v)
+			if !isCategory(category) {
+				return fail("unknown category '%s'", v)
+			}
+			q.Category = append(q.Category, category)
+		}
+	case filterDay:
+		for _, v := range values {
+			days, err := parseDays(v)
+			if err != nil {
+				return fail("%s", err)
+			}
+			q.Day = append(q.Day, days...)
+		}
+	case filterTime:
+		from, to, err := splitQueryRange(value)
+		if err == nil && from != "" {
+			q.FromTime, err = parseTimeOfDay(from)
+		}
+		if err == nil && to != "" {
+			q.ToTime, err = parseTimeOfDay(to)
+		}
+		if err != nil {
+			return fail("%s, expected a window like 06:00-08:00", err)
+		}
+	case filterDuration:
+		from, to, err := splitQueryRange(value)
+		if err == nil && from != "" {
+			q.MinDuration, err = time.ParseDuration(from)
+		}
+		if err == nil && to != "" {
+			q.MaxDuration, err = time.ParseDuration(to)
+		}
+		if err != nil {
+			return fail("%s, expected a range like 45m-1h", err)
+		}
+	case filterAfter, filterBefore:
+		t, err := parseQueryTime(value, now)
+		if err != nil {
+			return fail("expected a date like 2018-04-01, 2018-04-01T06:00, today or +7d")
+		}
+		if filter == filterAfter {
+			q.After = t
+		} else {
+			q.Before = t
+		}
+	case filterNear:
+		parts := strings.Split(value, ",")
+		lat, latErr := strconv.ParseFloat(parts[0], 64)
+		lon, lonErr := strconv.ParseFloat(parts[len(parts)-1], 64)
+		if len(parts) != 2 || latErr != nil || lonErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return fail("expected a latitude and longitude like -36.85,174.76")
+		}
+		q.Near = Coordinates{Latitude: lat, Longitude: lon}
+	case filterSort:
+		sortBy := strings.ToLower(value)
+		q.Descending = strings.HasPrefix(sortBy, "-")
+		q.SortBy = strings.TrimPrefix(sortBy, "-")
+		switch q.SortBy {
+		case SortStartTime, SortGym, SortName, SortDuration, SortDistance:
+		default:
+			return fail("unknown sort, expected one of start, gym, name, duration or distance")
+		}
+	case filterLimit, filterOffset:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fail("expected a non-negative number")
+		}
+		if filter == filterLimit {
+			q.Limit = n
+		} else {
+			q.Offset = n
+		}
+	default:
+		return fail("unknown filter '%s', expected one of %s", filter, strings.Join(queryFilters, ", "))
+	}
+	return nil
+}
+
+// splitQuery splits a query into terms on whitespace, keeping quoted values together
+func splitQuery(query string) ([]queryTerm, error) {
+	var terms []queryTerm
+	start := -1
+	quoted := false
+	for i, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			if start < 0 {
+				start = i
+			}
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if start >= 0 {
+				terms = append(terms, queryTerm{query[start:i], start})
+				start = -1
+			}
+		case start < 0:
+			start = i
+		}
+	}
+	if quoted {
+		return nil, &QueryParseError{Position: start, Term: query[start:], Message: "missing a closing quote"}
+	}
+	if start >= 0 {
+		terms = append(terms, queryTerm{query[start:], start})
+	}
+	return terms, nil
+}
+
+// splitQueryValues splits a comma separated list of values, keeping quoted values together
+func splitQueryValues(value string) []string {
+	var values []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			values = append(values, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(values, current.String())
+}
+
+func splitQueryRange(value string) (string, string, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("'%s' is not a range", value)
+	}
+	return parts[0], parts[1], nil
+}
+
+func quoteQueryValue(v string) string {
+	if strings.ContainsAny(v, " \t,:\"") {
+		return `"` + strings.Replace(v, `"`, "", -1) + `"`
+	}
+	return v
+}
+
+func parseTimeOfDay(v string) (TimeOfDay, error) {
+	parts := strings.Split(v, ":")
+	hour, err := strconv.Atoi(parts[0])
+	minute := 0
+	if err == nil && len(parts) == 2 {
+		minute, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || len(parts) > 2 || hour < 0 || hour > 24 || minute < 0 || minute > 59 || hour == 24 && minute != 0 {
+		return 0, fmt.Errorf("'%s' is not a time", v)
+	}
+	return NewTimeOfDay(hour, minute), nil
+}
+
+func parseDays(v string) ([]time.Weekday, error) {
+	from, to, err := splitQueryRange(v)
+	if err != nil {
+		from, to = v, v
+	}
+	start, ok := parseWeekday(from)
+	if !ok {
+		return nil, fmt.Errorf("unknown day '%s'", from)
+	}
+	end, ok := parseWeekday(to)
+	if !ok {
+		return nil, fmt.Errorf("unknown day '%s'", to)
+	}
+	days := []time.Weekday{start}
+	for d := start; d != end; {
+		d = (d + 1) % 7
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+func parseWeekday(v string) (time.Weekday, bool) {
+	v = strings.ToLower(v)
+	if len(v) < 3 {
+		return 0, false
+	}
+	for i := range weekdayNames {
+		if strings.HasPrefix(strings.ToLower(time.Weekday(i).String()), v) {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// formatDays writes days starting from Monday, collapsing three or more consecutive days into a range
+func formatDays(days []time.Weekday) string {
+	seen := map[time.Weekday]bool{}
+	for _, d := range days {
+		seen[d] = true
+	}
+	var ordered []time.Weekday
+	for i := 1; i <= 7; i++ {
+		if d := time.Weekday(i % 7); seen[d] {
+			ordered = append(ordered, d)
+		}
+	}
+	var parts []string
+	for i := 0; i < len(ordered); {
+		j := i
+		for j+1 < len(ordered) && (ordered[j]+1)%7 == ordered[j+1] {
+			j++
+		}
+		if j-i >= 2 {
+			parts = append(parts, weekdayNames[ordered[i]]+"-"+weekdayNames[ordered[j]])
+		} else {
+			for k := i; k <= j; k++ {
+				parts = append(parts, weekdayNames[ordered[k]])
+			}
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseQueryTime(v string, now time.Time) (time.Time, error) {
+	switch strings.ToLower(v) {
+	case "now":
+		return now, nil
+	case "today":
+		return startOfDay(now), nil
+	case "tomorrow":
+		return startOfDay(now).AddDate(0, 0, 1), nil
+	}
+	if strings.HasPrefix(v, "+") && len(v) > 2 {
+		n, err := strconv.Atoi(v[1 : len(v)-1])
+		if err == nil && n >= 0 {
+			switch v[len(v)-1] {
+			case 'h':
+				return now.Add(time.Duration(n) * time.Hour), nil
+			case 'd':
+				return now.AddDate(0, 0, n), nil
+			case 'w':
+				return now.AddDate(0, 0, 7*n), nil
+			}
+		}
+	}
+	t, err := time.ParseInLocation(queryDateTimeSecondsFormat, v, gymLocation)
+	if err != nil {
+		t, err = time.ParseInLocation(queryDateTimeFormat, v, gymLocation)
+	}
+	if err != nil {
+		t, err = time.ParseInLocation(queryDateFormat, v, gymLocation)
+	}
+	return t, err
+}
+
+// formatQueryTime writes a time in the gyms' timezone, only including seconds when it has them
+func formatQueryTime(t time.Time) string {
+	t = localTime(t)
+	switch {
+	case t.Second() != 0 || t.Nanosecond() != 0:
+		return t.Format(queryDateTimeSecondsFormat)
+	case t.Hour() == 0 && t.Minute() == 0:
+		return t.Format(queryDateFormat)
+	}
+	return t.Format(queryDateTimeFormat)
+}
+
+func formatQueryFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatQueryDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+func gymNames(gyms []Gym) []string {
+	var names []string
+	for _, g := range gyms {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+func isCategory(category string) bool {
+	for _, c := range Classes {
+		if c.Category == category {
+			return true
+		}
+	}
+	return false
+}
+
+// classesInCategory returns the names of the classes in the catalogue in a category
+func classesInCategory(category string) []string {
+	var names []string
+	for _, c := range Classes {
+		if c.Category == category {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,148 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type parseQueryTest struct {
+	query    string
+	expected GymQuery
+	err      bool
+}
+
+func TestParseQuery(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	parseQueryTests := []parseQueryTest{
+		{
+			query: "class:rpm,bodypump gym:city after:2018-04-01 day:mon-fri time:06:00-08:00 -class:yoga",
+			expected: GymQuery{
+				Class:        []string{"rpm", "bodypump"},
+				Gym:          []Gym{GetGymByName("city")},
+				After:        time.Date(2018, 4, 1, 0, 0, 0, 0, gymLocation),
+				Day:          weekdays,
+				FromTime:     NewTimeOfDay(6, 0),
+				ToTime:       NewTimeOfDay(8, 0),
+				ExcludeClass: []string{"yoga"},
+			},
+		},
+		{
+			query: `rpm -yoga -gym:takapuna studio:"RPM Studio" category:Cardio`,
+			expected: GymQuery{
+				Class:        []string{"rpm"},
+				ExcludeClass: []string{"yoga"},
+				ExcludeGym:   []Gym{GetGymByName("takapuna")},
+				Location:     []string{"RPM Studio"},
+				Category:     []string{CategoryCardio},
+			},
+		},
+		{
+			query: `class:"grit strength" day:sat-mon,wednesday time:18- duration:-45m before:2018-04-08T17:30`,
+			expected: GymQuery{
+				Class:       []string{"grit strength"},
+				Day:         []time.Weekday{time.Saturday, time.Sunday, time.Monday, time.Wednesday},
+				FromTime:    NewTimeOfDay(18, 0),
+				MaxDuration: 45 * time.Minute,
+				Before:      time.Date(2018, 4, 8, 17, 30, 0, 0, gymLocation),
+			},
+		},
+		{
+			query:    "sort:-duration limit:20 offset:40",
+			expected: GymQuery{SortBy: SortDuration, Descending: true, Limit: 20, Offset: 40},
+		},
+		{
+			query:    "near:-36.8485,174.7633 sort:distance",
+			expected: GymQuery{Near: Coordinates{Latitude: -36.8485, Longitude: 174.7633}, SortBy: SortDistance},
+		},
+		{
+			query:    "after:2018-04-01T06:00:30",
+			expected: GymQuery{After: time.Date(2018, 4, 1, 6, 0, 30, 0, gymLocation)},
+		},
+		{
+			query:    "time:22:00-24:00",
+			expected: GymQuery{FromTime: NewTimeOfDay(22, 0), ToTime: NewTimeOfDay(24, 0)},
+		},
+		{query: "", expected: GymQuery{}},
+		{query: "clas:rpm", err: true},
+		{query: "gym:auckland", err: true},
+		{query: "day:funday", err: true},
+		{query: "time:6am", err: true},
+		{query: "time:24:30", err: true},
+		{query: "time:22:00-24:01", err: true},
+		{query: "after:yesterday", err: true},
+		{query: "-day:mon", err: true},
+		{query: "class:", err: true},
+		{query: `studio:"RPM Studio`, err: true},
+		{query: "sort:instructor", err: true},
+		{query: "limit:-1", err: true},
+		{query: "near:-36.8485", err: true},
+		{query: "near:-36.8485,174.7633,0", err: true},
+		{query: "near:-96,174.7633", err: true},
+		{query: "near:north,west", err: true},
+	}
+	for _, test := range parseQueryTests {
+		q, err := ParseQuery(test.query)
+		if test.err {
+			assert.Error(t, err, "Expected an error parsing '%s'", test.query)
+			continue
+		}
+		assert.NoError(t, err, "Failed to parse '%s'", test.query)
+		assert.Equal(t, test.expected, q, "Did not get the expected query parsing '%s'", test.query)
+	}
+}
+
+func TestParseQueryErrorPosition(t *testing.T) {
+	_, err := ParseQuery("class:rpm day:funday")
+	parseErr, ok := err.(*QueryParseError)
+	if !ok {
+		t.Fatalf("Expected a QueryParseError but got %v", err)
+	}
+	assert.Equal(t, 10, parseErr.Position, "Did not get the position of the invalid term")
+	assert.Equal(t, "day:funday", parseErr.Term, "Did not get the invalid term")
+}
+
+func TestFormatQuery(t *testing.T) {
+	queries := []string{
+		"class:rpm,bodypump -class:yoga gym:city day:mon-fri time:06:00-08:00 after:2018-04-01",
+		`class:"grit strength" -gym:takapuna studio:"RPM Studio" category:cardio day:mon,wed,sat,sun time:18:00- duration:45m-1h30m before:2018-04-08T17:30`,
+		"sort:-name limit:20 offset:40",
+		"after:2018-04-01T06:00:30.5 before:2018-04-01T07:00 near:-36.8485,174.7633 sort:distance",
+		"",
+	}
+	for _, query := range queries {
+		q, err := ParseQuery(query)
+		assert.NoError(t, err, "Failed to parse '%s'", query)
+		assert.Equal(t, query, FormatQuery(q), "Formatting did not return the original query")
+		reparsed, err := ParseQuery(FormatQuery(q))
+		assert.NoError(t, err, "Failed to parse formatted query '%s'", FormatQuery(q))
+		assert.Equal(t, q, reparsed, "Parsing a formatted query did not return the same query")
+	}
+}
+
+func TestParseQueryRelativeDates(t *testing.T) {
+	now := time.Date(2018, 4, 3, 10, 30, 0, 0, gymLocation)
+	today := time.Date(2018, 4, 3, 0, 0, 0, 0, gymLocation)
+	var tests = []struct {
+		query  string
+		after  time.Time
+		before time.Time
+	}{
+		{"after:now before:+7d", now, now.AddDate(0, 0, 7)},
+		{"after:today before:tomorrow", today, today.AddDate(0, 0, 1)},
+		{"after:tomorrow before:+2w", today.AddDate(0, 0, 1), now.AddDate(0, 0, 14)},
+		{"after:+1h before:+12h", now.Add(time.Hour), now.Add(12 * time.Hour)},
+	}
+	for _, test := range tests {
+		q, err := ParseQueryAt(test.query, now)
+		assert.NoError(t, err, "Failed to parse '%s'", test.query)
+		assert.True(t, test.after.Equal(q.After), "%s: after %v", test.query, q.After)
+		assert.True(t, test.before.Equal(q.Before), "%s: before %v", test.query, q.Before)
+	}
+
+	for _, query := range []string{"after:+7", "after:+xd", "before:+-1d", "before:yesterday"} {
+		_, err := ParseQueryAt(query, now)
+		assert.Error(t, err, "Expected an error parsing '%s'", query)
+	}
+}
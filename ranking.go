@@ -0,0 +1,97 @@
+package lm
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ranked describes how many classes share a value such as a day, hour, class or gym, their share of all the
+// classes and when the most recent of them was
+type Ranked struct {
+	Key    string    `json:"key"`
+	Count  int       `json:"count"`
+	Share  float64   `json:"share"`
+	Latest time.Time `json:"latest"`
+}
+
+// RankClasses ranks the class types by the number of classes, see rankBy
+func (g GymClasses) RankClasses(n int) []Ranked {
+	return g.rankBy(n, func(c GymClass) string { return c.Name })
+}
+
+// RankGyms ranks the gyms by the number of classes at them, see rankBy
+func (g GymClasses) RankGyms(n int) []Ranked {
+	return g.rankBy(n, func(c GymClass) string { return c.Gym })
+}
+
+// RankDays ranks the days of the week in loc by the number of classes on them, see rankBy. The keys are
+// names such as "Monday"
+func (g GymClasses) RankDays(loc *time.Location, n int) []Ranked {
+	return g.rankBy(n, func(c GymClass) string { return c.StartDateTime.In(loc).Weekday().String() })
+}
+
+// RankHours ranks the hours of the day in loc by the number of classes starting in them, see rankBy. The
+// keys are the start of the hour formatted as 15:04
+func (g GymClasses) RankHours(loc *time.Location, n int) []Ranked {
+	return g.rankBy(n, func(c GymClass) string { return NewTimeOfDay(c.StartDateTime.In(loc).Hour(), 0).String() })
+}
+
+// rankBy counts the classes by key and returns the top n keys, or every key when n isn't positive. Keys with
+// the same count are ranked by their most recent class and then by key, so the ranking is the same every
+// time. Every ranking of classes goes through rankBy so they all break ties this way. Classes with an empty
+// key are left out, and shares are of the classes which have a key
+func (g GymClasses) rankBy(n int, key func(GymClass) string) []Ranked {
+	counts := make(map[string]*Ranked)
+	counted := 0
+	for _, c := range g {
+		k := key(c)
+		if k == "" {
+			continue
+		}
+		counted++
+		r, ok := counts[k]
+		if !ok {
+			r = &Ranked{Key: k}
+			counts[k] = r
+		}
+		r.add(c)
+	}
+	ranked := make([]Ranked, 0, len(counts))
+	for _, r := range counts {
+		r.Share = float64(r.Count) / float64(counted)
+		ranked = append(ranked, *r)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if !a.Latest.Equal(b.Latest) {
+			return a.Latest.After(b.Latest)
+		}
+		return a.Key < b.Key
+	})
+	if n > 0 && n < len(ranked) {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// mostFrequent returns the key with the most classes, ranked by rankBy, or zero when there aren't any classes
+func (g GymClasses) mostFrequent(key func(GymClass) int) int {
+	top := g.rankBy(1, func(c GymClass) string { return strconv.Itoa(key(c)) })
+	if len(top) == 0 {
+		return 0
+	}
+	k, _ := strconv.Atoi(top[0].Key)
+	return k
+}
+
+// add counts a class against the ranked value
+func (r *Ranked) add(c GymClass) {
+	r.Count++
+	if c.StartDateTime.After(r.Latest) {
+		r.Latest = c.StartDateTime
+	}
+}
@@ -0,0 +1,67 @@
+package lm
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRanking(t *testing.T) {
+	// RPM and BODYPUMP are tied, but BODYPUMP was gone to more recently
+	classes := GymClasses{
+		juneClass("RPM", "city", 4, 6),
+		juneClass("BODYPUMP", "takapuna", 5, 18),
+		juneClass("RPM", "takapuna", 6, 6),
+		juneClass("BODYPUMP", "city", 7, 18),
+		juneClass("YOGA", "britomart", 2, 18),
+	}
+	latest := time.Date(2018, 6, 7, 18, 0, 0, 0, time.UTC)
+	assert.Equal(t, []Ranked{
+		{"BODYPUMP", 2, 0.4, latest},
+		{"RPM", 2, 0.4, time.Date(2018, 6, 6, 6, 0, 0, 0, time.UTC)},
+		{"YOGA", 1, 0.2, time.Date(2018, 6, 2, 18, 0, 0, 0, time.UTC)},
+	}, classes.RankClasses(0))
+	assert.Equal(t, []string{"BODYPUMP", "RPM"}, rankedKeys(classes.RankClasses(2)))
+	assert.Equal(t, []string{"city", "takapuna", "britomart"}, rankedKeys(classes.RankGyms(10)))
+	assert.Equal(t, []string{"18:00", "06:00"}, rankedKeys(classes.RankHours(time.UTC, 0)))
+	assert.Equal(t, []string{"Thursday", "Wednesday", "Tuesday", "Monday", "Saturday"}, rankedKeys(classes.RankDays(time.UTC, 0)))
+	assert.Empty(t, GymClasses{}.RankClasses(3))
+
+	// The same classes in any order are ranked the same way
+	for i := 0; i < 10; i++ {
+		shuffled := make(GymClasses, len(classes))
+		for j, k := range rand.Perm(len(classes)) {
+			shuffled[j] = classes[k]
+		}
+		assert.Equal(t, []string{"BODYPUMP", "RPM", "YOGA"}, rankedKeys(shuffled.RankClasses(0)))
+		assert.Equal(t, []string{"city", "takapuna", "britomart"}, rankedKeys(shuffled.RankGyms(0)))
+		assert.Equal(t, "BODYPUMP", shuffled.MostFrequentedClass())
+		assert.Equal(t, "city", shuffled.MostFrequentedGym())
+		assert.Equal(t, 18, shuffled.MostFrequentedTime())
+		assert.Equal(t, int(time.Thursday), shuffled.MostFrequentedDay())
+	}
+
+	// Shares are of the classes with a gym
+	withoutGym := append(GymClasses{juneClass("RPM", "", 8, 6)}, classes...)
+	assert.Equal(t, []GymPreference{{GetGymByName("city"), 0.4}, {GetGymByName("takapuna"), 0.4}, {GetGymByName("britomart"), 0.2}}, withoutGym.GymPreferences())
+	assert.Equal(t, []ClassPreference{{"BODYPUMP", 0.4}, {"RPM", 0.4}, {"YOGA", 0.2}}, classes.ClassPreferences())
+	assert.Equal(t, []GymPreference{{GetGymByName("city"), 0.4}, {GetGymByName("takapuna"), 0.4}, {GetGymByName("britomart"), 0.2}}, classes.GymPreferences())
+
+	assert.Equal(t, "", GymClasses{}.MostFrequentedClass())
+	assert.Equal(t, 0, GymClasses{}.MostFrequentedTime())
+}
+
+// juneClass returns a class starting on the hour given in UTC on a day in June 2018
+func juneClass(name string, gym string, day int, hour int) GymClass {
+	return GymClass{Name: name, Gym: gym, StartDateTime: time.Date(2018, 6, day, hour, 0, 0, 0, time.UTC)}
+}
+
+func rankedKeys(ranked []Ranked) []string {
+	keys := make([]string, len(ranked))
+	for i, r := range ranked {
+		keys[i] = r.Key
+	}
+	return keys
+}
@@ -0,0 +1,169 @@
+package lm
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// SavedSearch is a query a user has saved so they can run it again
+// Text is written in the query language and may use relative dates such as "after:today before:+7d", which
+// are worked out each time the search is run. Query is used when there is no Text. When Within is set the
+// search covers the period from when it is run until Within later, replacing any dates in the query
+type SavedSearch struct {
+	ID      int           `json:"id" db:"id" storm:"id,increment"`
+	UserID  string        `json:"userID" db:"user_id" storm:"index"`
+	Name    string        `json:"name" db:"name"`
+	Text    string        `json:"text,omitempty" db:"text"`
+	Query   GymQuery      `json:"query" db:"query"`
+	Within  time.Duration `json:"within,omitempty" db:"within"`
+	Created time.Time     `json:"created" db:"created"`
+	Updated time.Time     `json:"updated" db:"updated"`
+}
+
+// Resolve returns the GymQuery for the saved search with any relative dates worked out from now
+func (s SavedSearch) Resolve(now time.Time) (GymQuery, error) {
+	q := s.Query
+	if s.Text != "" {
+		var err error
+		q, err = ParseQueryAt(s.Text, now)
+		if err != nil {
+			return GymQuery{}, err
+		}
+	}
+	if s.Within > 0 {
+		q.After = now
+		q.Before = now.Add(s.Within)
+	}
+	return q, nil
+}
+
+// StoreSavedSearch stores a new saved search and returns it with its ID set
+// A user's saved searches must have different names
+func StoreSavedSearch(search SavedSearch, dbConfig *Config) (SavedSearch, error) {
+	err := validateSavedSearch(search, dbConfig)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	search.ID = 0
+	search.Created = dbConfig.now()
+	search.Updated = search.Created
+	err = dbConfig.DB.Save(&search)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": search.UserID}).Error("Failed to store saved search")
+		return SavedSearch{}, err
+	}
+	log.Infof("Stored saved search %d for user %s", search.ID, search.UserID)
+	return search, nil
+}
+
+// GetSavedSearch returns the saved search with the ID provided
+func GetSavedSearch(id int, dbConfig *Config) (SavedSearch, error) {
+	var search SavedSearch
+	err := dbConfig.DB.One("ID", id, &search)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "id": id}).Error("Failed to get saved search")
+		return SavedSearch{}, err
+	}
+	return search, nil
+}
+
+// QuerySavedSearches returns all of a user's saved searches sorted by name
+func QuerySavedSearches(user string, dbConfig *Config) ([]SavedSearch, error) {
+	var searches []SavedSearch
+	err := dbConfig.DB.Find("UserID", user, &searches)
+	if err == storm.ErrNotFound {
+		return []SavedSearch{}, nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to get saved searches")
+		return []SavedSearch{}, err
+	}
+	sort.Slice(searches, func(i, j int) bool {
+		return strings.ToLower(searches[i].Name) < strings.ToLower(searches[j].Name)
+	})
+	return searches, nil
+}
+
+// UpdateSavedSearch replaces the name and query of an existing saved search, it can't be given to another user
+func UpdateSavedSearch(search SavedSearch, dbConfig *Config) error {
+	existing, err := GetSavedSearch(search.ID, dbConfig)
+	if err != nil {
+		return err
+	}
+	if existing.UserID != search.UserID {
+		return errors.New("Saved search belongs to a different user")
+	}
+	err = validateSavedSearch(search, dbConfig)
+	if err != nil {
+		return err
+	}
+	search.Created = existing.Created
+	search.Updated = dbConfig.now()
+	err = dbConfig.DB.Save(&search)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "id": search.ID}).Error("Failed to update saved search")
+		return err
+	}
+	return nil
+}
+
+// DeleteSavedSearch deletes the saved search with the ID provided
+func DeleteSavedSearch(id int, dbConfig *Config) error {
+	search, err := GetSavedSearch(id, dbConfig)
+	if err != nil {
+		return err
+	}
+	err = dbConfig.DB.DeleteStruct(&search)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "id": id}).Error("Failed to delete saved search")
+		return err
+	}
+	return nil
+}
+
+// RunSavedSearch runs the saved search with the ID provided, working out any relative dates from the current time
+func RunSavedSearch(id int, dbConfig *Config) (SearchResult, error) {
+	search, err := GetSavedSearch(id, dbConfig)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	query, err := search.Resolve(dbConfig.now())
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "id": id}).Error("Failed to parse saved search")
+		return SearchResult{}, err
+	}
+	return runSearch(query, dbConfig)
+}
+
+// validateSavedSearch checks the search has an owner, a name unique to the owner and a valid query
+func validateSavedSearch(search SavedSearch, dbConfig *Config) error {
+	if search.UserID == "" {
+		return errors.New("Saved search must have a user")
+	}
+	if strings.TrimSpace(search.Name) == "" {
+		return errors.New("Saved search must have a name")
+	}
+	if search.Within < 0 {
+		return errors.New("Saved search can't cover a negative period")
+	}
+	if search.Text != "" {
+		_, err := ParseQuery(search.Text)
+		if err != nil {
+			return err
+		}
+	}
+	searches, err := QuerySavedSearches(search.UserID, dbConfig)
+	if err != nil {
+		return err
+	}
+	for _, s := range searches {
+		if s.ID != search.ID && strings.EqualFold(s.Name, search.Name) {
+			return errors.New("A saved search called " + search.Name + " already exists")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavedSearchResolve(t *testing.T) {
+	now := time.Date(2018, 4, 3, 10, 30, 0, 0, gymLocation)
+	later := time.Date(2018, 4, 10, 10, 30, 0, 0, gymLocation)
+	frozen := GymQuery{Class: []string{"RPM"}, After: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), Before: time.Date(2017, 1, 8, 0, 0, 0, 0, time.UTC)}
+
+	// Relative dates in the query language move with the time the search is run
+	search := SavedSearch{UserID: "123", Name: "This week's RPM", Text: "rpm gym:city after:now before:+7d"}
+	q, err := search.Resolve(now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rpm"}, q.Class)
+	assert.True(t, now.Equal(q.After))
+	assert.True(t, now.AddDate(0, 0, 7).Equal(q.Before))
+	q, err = search.Resolve(later)
+	assert.NoError(t, err)
+	assert.True(t, later.Equal(q.After))
+
+	// A stored GymQuery keeps its dates unless Within is set
+	search = SavedSearch{UserID: "123", Name: "RPM", Query: frozen}
+	q, err = search.Resolve(now)
+	assert.NoError(t, err)
+	assert.Equal(t, frozen, q)
+	search.Within = 24 * time.Hour
+	q, err = search.Resolve(now)
+	assert.NoError(t, err)
+	assert.Equal(t, frozen.Class, q.Class)
+	assert.True(t, now.Equal(q.After))
+	assert.True(t, now.Add(24*time.Hour).Equal(q.Before))
+}
+
+func TestSavedSearches(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+	_, err = StoreClasses(testClasses, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes %s", err)
+	}
+
+	rpm, err := StoreSavedSearch(SavedSearch{UserID: "123", Name: "RPM at city", Text: "rpm gym:city after:now before:+1d"}, testConfig)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, rpm.ID)
+	_, err = StoreSavedSearch(SavedSearch{UserID: "123", Name: "Anything", Within: 7 * 24 * time.Hour}, testConfig)
+	assert.NoError(t, err)
+	_, err = StoreSavedSearch(SavedSearch{UserID: "456", Name: "RPM at city", Text: "rpm"}, testConfig)
+	assert.NoError(t, err, "Different users can use the same name")
+
+	// Invalid searches
+	_, err = StoreSavedSearch(SavedSearch{UserID: "123", Name: "rpm at city", Text: "rpm"}, testConfig)
+	assert.Error(t, err, "Expected an error for a duplicate name")
+	_, err = StoreSavedSearch(SavedSearch{UserID: "123", Name: "Bad", Text: "day:funday"}, testConfig)
+	assert.Error(t, err, "Expected an error for an invalid query")
+	_, err = StoreSavedSearch(SavedSearch{Name: "No user"}, testConfig)
+	assert.Error(t, err, "Expected an error without a user")
+
+	searches, err := QuerySavedSearches("123", testConfig)
+	assert.NoError(t, err)
+	if assert.Len(t, searches, 2) {
+		assert.Equal(t, "Anything", searches[0].Name)
+		assert.Equal(t, "RPM at city", searches[1].Name)
+	}
+
+	// The class starting now has already started, so only the class starting in an hour is found
+	result, err := RunSavedSearch(rpm.ID, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Total)
+
+	rpm.Text = "rpm after:today before:+1d"
+	err = UpdateSavedSearch(rpm, testConfig)
+	assert.NoError(t, err)
+	updated, err := GetSavedSearch(rpm.ID, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, rpm.Text, updated.Text)
+	assert.True(t, rpm.Created.Equal(updated.Created))
+
+	rpm.UserID = "456"
+	assert.Error(t, UpdateSavedSearch(rpm, testConfig), "Expected an error moving a search to another user")
+
+	assert.NoError(t, DeleteSavedSearch(rpm.ID, testConfig))
+	_, err = GetSavedSearch(rpm.ID, testConfig)
+	assert.Error(t, err)
+	_, err = RunSavedSearch(rpm.ID, testConfig)
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package lm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SearchResult is the result of a natural language search, with the query it was interpreted as
+type SearchResult struct {
+	Query       GymQuery   `json:"query"`
+	Explanation string     `json:"explanation"`
+	Classes     GymClasses `json:"classes"`
+	Total       int        `json:"total"`
+	NextCursor  string     `json:"nextCursor,omitempty"`
+}
+
+// SearchClasses parses a natural language query, runs it and returns the matching classes along with the
+// interpreted query and an explanation of it
+func SearchClasses(query string, dbConfig *Config) (SearchResult, error) {
+	gymQuery, err := QueryClassesByName(query, dbConfig)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return runSearch(gymQuery, dbConfig)
+}
+
+// runSearch runs the query and explains it
+func runSearch(gymQuery GymQuery, dbConfig *Config) (SearchResult, error) {
+	page, err := QueryClassesPage(gymQuery, dbConfig)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	// Dates are explained in the configured timezone, which relative dates were worked out in
+	explained := gymQuery
+	if explained.Timezone == nil {
+		explained.Timezone = dbConfig.now().Location()
+	}
+	return SearchResult{
+		Query:       gymQuery,
+		Explanation: ExplainQuery(explained),
+		Classes:     page.Classes,
+		Total:       page.Total,
+		NextCursor:  page.NextCursor,
+	}, nil
+}
+
+// ExplainQuery describes a GymQuery in words, e.g. "RPM at city between Tue 6pm and Wed 12am"
+// Dates are shown in the query's Timezone, which defaults to the gyms' timezone
+func ExplainQuery(q GymQuery) string {
+	var parts []string
+	switch {
+	case len(q.Class) > 0:
+		parts = append(parts, strings.Join(q.Class, " or "))
+	case len(q.Category) > 0:
+		parts = append(parts, strings.Join(q.Category, " or ")+" classes")
+	default:
+		parts = append(parts, "Any class")
+	}
+	if len(q.Class) > 0 && len(q.Category) > 0 {
+		parts = append(parts, "("+strings.Join(q.Category, " or ")+")")
+	}
+	if len(q.ExcludeClass) > 0 {
+		parts = append(parts, "except "+strings.Join(q.ExcludeClass, " or "))
+	}
+	if len(q.Gym) > 0 {
+		parts = append(parts, "at "+strings.Join(gymNames(q.Gym), " or "))
+	}
+	if len(q.ExcludeGym) > 0 {
+		parts = append(parts, "not at "+strings.Join(gymNames(q.ExcludeGym), " or "))
+	}
+	if len(q.Location) > 0 {
+		parts = append(parts, "in "+strings.Join(q.Location, " or "))
+	}
+	if len(q.Day) > 0 {
+		parts = append(parts, "on "+strings.Title(strings.Replace(formatDays(q.Day), ",", ", ", -1)))
+	}
+	switch {
+	case q.FromTime != 0 && q.ToTime != 0:
+		parts = append(parts, fmt.Sprintf("from %s to %s", explainTimeOfDay(q.FromTime), explainTimeOfDay(q.ToTime)))
+	case q.FromTime != 0:
+		parts = append(parts, "from "+explainTimeOfDay(q.FromTime))
+	case q.ToTime != 0:
+		parts = append(parts, "before "+explainTimeOfDay(q.ToTime))
+	}
+	switch {
+	case q.MinDuration != 0 && q.MaxDuration != 0:
+		parts = append(parts, fmt.Sprintf("lasting %s to %s", formatQueryDuration(q.MinDuration), formatQueryDuration(q.MaxDuration)))
+	case q.MinDuration != 0:
+		parts = append(parts, "lasting at least "+formatQueryDuration(q.MinDuration))
+	case q.MaxDuration != 0:
+		parts = append(parts, "lasting at most "+formatQueryDuration(q.MaxDuration))
+	}
+
+	// Only show the dates when the range is too long for the weekday to be unambiguous
+	withDate := q.After.IsZero() || q.Before.IsZero() || q.Before.Sub(q.After) > 6*24*time.Hour
+	switch {
+	case !q.After.IsZero() && !q.Before.IsZero():
+		parts = append(parts, fmt.Sprintf("between %s and %s", explainTime(q.localTime(q.After), withDate), explainTime(q.localTime(q.Before), withDate)))
+	case !q.After.IsZero():
+		parts = append(parts, "after "+explainTime(q.localTime(q.After), withDate))
+	case !q.Before.IsZero():
+		parts = append(parts, "before "+explainTime(q.localTime(q.Before), withDate))
+	}
+	return strings.Join(parts, " ")
+}
+
+// explainTime formats t in its own timezone, e.g. "Tue 6pm" or "Tue 3 Apr 6:30pm"
+func explainTime(t time.Time, withDate bool) string {
+	layout := "Mon "
+	if withDate {
+		layout += "2 Jan "
+	}
+	if t.Minute() == 0 {
+		layout += "3pm"
+	} else {
+		layout += "3:04pm"
+	}
+	return t.Format(layout)
+}
+
+func explainTimeOfDay(t TimeOfDay) string {
+	clock := time.Date(2000, 1, 1, t.Hour(), t.Minute(), 0, 0, time.UTC)
+	if t.Minute() == 0 {
+		return clock.Format("3pm")
+	}
+	return clock.Format("3:04pm")
+}
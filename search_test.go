@@ -0,0 +1,102 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainQuery(t *testing.T) {
+	nz := gymLocation
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatalf("Failed to load timezone %s", err)
+	}
+	var tests = []struct {
+		query    GymQuery
+		expected string
+	}{
+		{GymQuery{}, "Any class"},
+		{
+			GymQuery{
+				Class:  []string{"RPM"},
+				Gym:    []Gym{GetGymByName("city")},
+				After:  time.Date(2018, 4, 3, 18, 0, 0, 0, nz),
+				Before: time.Date(2018, 4, 4, 0, 0, 0, 0, nz)},
+			"RPM at city between Tue 6pm and Wed 12am"},
+		{
+			GymQuery{
+				Class:    []string{"RPM", "SPRINT"},
+				Day:      []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+				ToTime:   NewTimeOfDay(8, 30),
+				After:    time.Date(2018, 4, 3, 0, 0, 0, 0, nz),
+				Before:   time.Date(2018, 4, 17, 0, 0, 0, 0, nz),
+				Location: []string{"Studio 2"}},
+			"RPM or SPRINT in Studio 2 on Mon-Fri before 8:30am between Tue 3 Apr 12am and Tue 17 Apr 12am"},
+		{
+			GymQuery{
+				Category:     []string{CategoryCardio},
+				ExcludeClass: []string{"SPRINT"},
+				ExcludeGym:   []Gym{GetGymByName("takapuna")},
+				FromTime:     NewTimeOfDay(17, 0),
+				MinDuration:  45 * time.Minute,
+				After:        time.Date(2018, 4, 3, 10, 15, 0, 0, nz)},
+			"cardio classes except SPRINT not at takapuna from 5pm lasting at least 45m after Tue 3 Apr 10:15am"},
+		{
+			GymQuery{
+				Class:    []string{"RPM"},
+				After:    time.Date(2018, 4, 3, 18, 0, 0, 0, nz),
+				Before:   time.Date(2018, 4, 4, 0, 0, 0, 0, nz),
+				Timezone: sydney},
+			"RPM between Tue 4pm and Tue 10pm"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, ExplainQuery(test.query))
+	}
+}
+
+func TestSearchClasses(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+	_, err = StoreClasses(testClasses, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes %s", err)
+	}
+
+	after := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	testConfig.NLU = StaticNLU{
+		"rpm at city": Intent{
+			Classes:  []string{"RPM"},
+			Gyms:     []string{"city"},
+			DateTime: &DateTime{Type: DateTimeInterval, From: after, To: time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	result, err := SearchClasses("rpm at city", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Total)
+	assert.Len(t, result.Classes, 2)
+	assert.Equal(t, []string{"RPM"}, result.Query.Class)
+	assert.Equal(t, ExplainQuery(result.Query), result.Explanation)
+
+	// Dates are explained in the configured timezone rather than the gyms'
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("Failed to load timezone %s", err)
+	}
+	testConfig.Timezone = london
+	result, err = SearchClasses("rpm at city", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "RPM at city between Sat 1 Jan 12am and Thu 1 Jan 12am", result.Explanation)
+
+	_, err = SearchClasses("unknown", testConfig)
+	assert.Error(t, err)
+}
@@ -0,0 +1,320 @@
+package lm
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultWeeklyTarget is the number of classes a week a user aims for when the configuration doesn't set one
+const DefaultWeeklyTarget = 3
+
+// ConsistencyWindows are the number of weeks that consistency scores are worked out over
+var ConsistencyWindows = []int{4, 12, 52}
+
+// RateWindows are the number of weeks that rates are worked out over, the rate over all time is always included
+var RateWindows = []int{4, 12}
+
+// The trends of a Rate compared with the rate over a longer window
+const (
+	TrendUp     = "up"
+	TrendDown   = "down"
+	TrendSteady = "steady"
+)
+
+// TrendThreshold is how much a rate has to change relative to the rate over a longer window to be a trend
+const TrendThreshold = 0.1
+
+// weeksPerMonth is the average number of weeks in a month
+const weeksPerMonth = 52.0 / 12.0
+
+// Rate describes how many classes a user went to per week and per month over the last Weeks weeks, where
+// zero Weeks means all time. Trend compares the rate with the next longer window and is empty for all time
+type Rate struct {
+	Weeks    int     `json:"weeks"`
+	Classes  int     `json:"classes"`
+	PerWeek  float64 `json:"perWeek"`
+	PerMonth float64 `json:"perMonth"`
+	Trend    string  `json:"trend,omitempty"`
+}
+
+// Consistency describes how regularly a user goes to classes. Streaks count consecutive days with a class
+// and consecutive weeks with at least WeeklyTarget classes, and a current streak is still running when the
+// last class was yesterday or the target hasn't been met yet this week
+// AverageGap is the average number of days between days with a class, and LongestBreak is the longest period
+// without a class, including the break since the last class
+type Consistency struct {
+	CurrentStreak     int                `json:"currentStreak"`
+	LongestStreak     int                `json:"longestStreak"`
+	WeeklyTarget      int                `json:"weeklyTarget"`
+	CurrentWeekStreak int                `json:"currentWeekStreak"`
+	LongestWeekStreak int                `json:"longestWeekStreak"`
+	AverageGap        float64            `json:"averageGap"`
+	LongestBreak      Interval           `json:"longestBreak"`
+	LongestBreakDays  int                `json:"longestBreakDays"`
+	Scores            []ConsistencyScore `json:"scores"`
+}
+
+// ConsistencyScore is the share of the complete weeks in a window that met the weekly target. Weeks before
+// the first class aren't counted, so new users aren't penalised
+type ConsistencyScore struct {
+	Weeks int     `json:"weeks"`
+	Score float64 `json:"score"`
+}
+
+// The periods that workout frequency can be counted over
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// Frequency returns the number of classes in each day, week or month in loc, sorted from the period of the
+// first class to the period of the last. Periods without any classes are included with a count of zero
+// Weeks are ISO weeks which start on a Monday
+func (g GymClasses) Frequency(granularity string, loc *time.Location) []WorkOutFrequency {
+	f := []WorkOutFrequency{}
+	if len(g) == 0 || !validFrequency(granularity) {
+		return f
+	}
+	counts := make(map[int64]int)
+	var first, last time.Time
+	for i, c := range g {
+		start := periodStart(c.StartDateTime.In(loc), granularity)
+		counts[start.Unix()]++
+		if i == 0 || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+	for p := first; !p.After(last); p = nextPeriod(p, granularity) {
+		f = append(f, newWorkOutFrequency(p, granularity, counts[p.Unix()]))
+	}
+	return f
+}
+
+func validFrequency(granularity string) bool {
+	switch granularity {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+		return true
+	}
+	return false
+}
+
+// periodStart returns midnight at the start of the day, week or month containing t, in t's location
+func periodStart(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case FrequencyMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case FrequencyWeekly:
+		// Weekday counts from Sunday but ISO weeks start on Monday
+		return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// nextPeriod returns the start of the period after the one starting at t, using the calendar so that days
+// with daylight saving changes are handled
+func nextPeriod(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case FrequencyMonthly:
+		return t.AddDate(0, 1, 0)
+	case FrequencyWeekly:
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func newWorkOutFrequency(start time.Time, granularity string, count int) WorkOutFrequency {
+	f := WorkOutFrequency{Year: start.Year(), Start: start, Count: count}
+	switch granularity {
+	case FrequencyWeekly:
+		f.Year, f.Week = start.ISOWeek()
+	case FrequencyMonthly:
+		f.Month = start.Month()
+	case FrequencyDaily:
+		f.Month = start.Month()
+		f.Day = start.Day()
+	}
+	return f
+}
+
+// Consistency returns the streaks, gaps and consistency scores of the classes, using days and weeks in now's
+// location. Weeks start on a Monday
+func (g GymClasses) Consistency(now time.Time, weeklyTarget int) Consistency {
+	c := Consistency{WeeklyTarget: weeklyTarget, Scores: []ConsistencyScore{}}
+	if len(g) == 0 {
+		return c
+	}
+	loc := now.Location()
+	today := dayNumber(now)
+
+	// The days with a class in order, and how many classes there were each week
+	var days []int
+	seen := make(map[int]bool)
+	weeks := make(map[int]int)
+	for _, class := range g {
+		d := dayNumber(class.StartDateTime.In(loc))
+		weeks[weekNumber(d)]++
+		if !seen[d] {
+			seen[d] = true
+			days = append(days, d)
+		}
+	}
+	sort.Ints(days)
+
+	streak := 1
+	c.LongestStreak = 1
+	gaps := 0
+	for i := 1; i < len(days); i++ {
+		gap := days[i] - days[i-1]
+		gaps += gap
+		if gap == 1 {
+			streak++
+		} else {
+			streak = 1
+		}
+		if streak > c.LongestStreak {
+			c.LongestStreak = streak
+		}
+		if gap-1 > c.LongestBreakDays {
+			c.LongestBreakDays = gap - 1
+			c.LongestBreak = Interval{Start: dayStart(days[i-1]+1, loc), End: dayStart(days[i], loc)}
+		}
+	}
+	last := days[len(days)-1]
+	if last >= today-1 {
+		c.CurrentStreak = streak
+	} else if today-last-1 > c.LongestBreakDays {
+		c.LongestBreakDays = today - last - 1
+		c.LongestBreak = Interval{Start: dayStart(last+1, loc), End: now}
+	}
+	if len(days) > 1 {
+		c.AverageGap = float64(gaps) / float64(len(days)-1)
+	}
+
+	firstWeek := weekNumber(days[0])
+	lastWeek := weekNumber(last)
+	thisWeek := weekNumber(today)
+	met := func(w int) bool {
+		return weeks[w] >= weeklyTarget
+	}
+	streak = 0
+	for w := firstWeek; w <= lastWeek; w++ {
+		if met(w) {
+			streak++
+		} else {
+			streak = 0
+		}
+		if streak > c.LongestWeekStreak {
+			c.LongestWeekStreak = streak
+		}
+	}
+	// This week only breaks the streak once it is over
+	w := thisWeek
+	if !met(w) {
+		w--
+	}
+	for ; w >= firstWeek && met(w); w-- {
+		c.CurrentWeekStreak++
+	}
+
+	for _, window := range ConsistencyWindows {
+		counted, metTarget := 0, 0
+		for w := thisWeek - 1; w >= thisWeek-window && w >= firstWeek; w-- {
+			counted++
+			if met(w) {
+				metTarget++
+			}
+		}
+		score := ConsistencyScore{Weeks: window}
+		if counted > 0 {
+			score.Score = float64(metTarget) / float64(counted)
+		}
+		c.Scores = append(c.Scores, score)
+	}
+	return c
+}
+
+// dayNumber returns the number of days from 1970-01-01 to the date of t in t's location, ignoring the time of
+// day so that daylight saving changes don't matter
+func dayNumber(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// weekNumber returns the number of the Monday to Sunday week containing a day from dayNumber
+func weekNumber(day int) int {
+	// 1970-01-01 was a Thursday, so shift the days to count from the Monday before it
+	day += 3
+	if day < 0 {
+		return (day - 6) / 7
+	}
+	return day / 7
+}
+
+// dayStart returns midnight at the start of a day from dayNumber in loc
+func dayStart(day int, loc *time.Location) time.Time {
+	return time.Date(1970, 1, 1+day, 0, 0, 0, 0, loc)
+}
+
+// Rates returns the rate of classes over each window of weeks up until now followed by the rate over all
+// time, which runs from the oldest class until now. Windows should be in order from shortest to longest
+// Classes after now are only counted in the rate over all time, and windows never start before the oldest class
+func (g GymClasses) Rates(now time.Time, windows ...int) []Rate {
+	rates := make([]Rate, 0, len(windows)+1)
+	oldest := g.OldestClass().StartDateTime
+	for _, weeks := range windows {
+		since := now.AddDate(0, 0, -7*weeks)
+		r := Rate{Weeks: weeks}
+		for _, c := range g {
+			if c.StartDateTime.After(since) && !c.StartDateTime.After(now) {
+				r.Classes++
+			}
+		}
+		// A window longer than the user's history would make their rate look lower than it is
+		if oldest.After(since) {
+			since = oldest
+		}
+		r.PerWeek = perWeek(r.Classes, now.Sub(since))
+		rates = append(rates, r)
+	}
+
+	all := Rate{Classes: g.Total()}
+	if len(g) > 0 {
+		end := now
+		if latest := g.LatestClass().StartDateTime; latest.After(end) {
+			end = latest
+		}
+		all.PerWeek = perWeek(all.Classes, end.Sub(oldest))
+	}
+	rates = append(rates, all)
+
+	for i := range rates {
+		rates[i].PerMonth = rates[i].PerWeek * weeksPerMonth
+		if i < len(rates)-1 {
+			rates[i].Trend = trend(rates[i].PerWeek, rates[i+1].PerWeek)
+		}
+	}
+	return rates
+}
+
+// perWeek returns the number of classes per week over a period, treating periods shorter than a week as a week
+func perWeek(classes int, period time.Duration) float64 {
+	week := 7 * 24 * time.Hour
+	if period < week {
+		period = week
+	}
+	return float64(classes) / (float64(period) / float64(week))
+}
+
+// trend compares a recent rate with a rate over a longer window
+func trend(recent float64, longer float64) string {
+	switch {
+	case recent > longer*(1+TrendThreshold):
+		return TrendUp
+	case recent < longer*(1-TrendThreshold):
+		return TrendDown
+	}
+	return TrendSteady
+}
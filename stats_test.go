@@ -0,0 +1,169 @@
+package lm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrequency(t *testing.T) {
+	// Week 5 of 2017 and week 5 of 2018 must not be merged, and 2018-12-31 is in week 1 of 2019
+	classes := GymClasses{
+		startingAt(2018, 1, 31, 6),
+		startingAt(2017, 2, 1, 6),
+		startingAt(2017, 2, 2, 18),
+		startingAt(2017, 2, 14, 6),
+	}
+	weekly := classes.Frequency(FrequencyWeekly, time.UTC)
+	if assert.Len(t, weekly, 53) {
+		assert.Equal(t, WorkOutFrequency{Year: 2017, Week: 5, Start: time.Date(2017, 1, 30, 0, 0, 0, 0, time.UTC), Count: 2}, weekly[0])
+		assert.Equal(t, WorkOutFrequency{Year: 2017, Week: 6, Start: time.Date(2017, 2, 6, 0, 0, 0, 0, time.UTC), Count: 0}, weekly[1])
+		assert.Equal(t, WorkOutFrequency{Year: 2017, Week: 7, Start: time.Date(2017, 2, 13, 0, 0, 0, 0, time.UTC), Count: 1}, weekly[2])
+		assert.Equal(t, WorkOutFrequency{Year: 2018, Week: 5, Start: time.Date(2018, 1, 29, 0, 0, 0, 0, time.UTC), Count: 1}, weekly[52])
+	}
+
+	monthly := classes.Frequency(FrequencyMonthly, time.UTC)
+	if assert.Len(t, monthly, 12) {
+		assert.Equal(t, WorkOutFrequency{Year: 2017, Month: time.February, Start: time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC), Count: 3}, monthly[0])
+		assert.Equal(t, 0, monthly[1].Count)
+		assert.Equal(t, WorkOutFrequency{Year: 2018, Month: time.January, Start: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), Count: 1}, monthly[11])
+	}
+
+	daily := GymClasses{startingAt(2018, 12, 30, 6), startingAt(2018, 12, 31, 6), startingAt(2019, 1, 2, 6), startingAt(2019, 1, 2, 18)}.Frequency(FrequencyDaily, time.UTC)
+	assert.Equal(t, []int{1, 1, 0, 2}, frequencyCounts(daily))
+	assert.Equal(t, WorkOutFrequency{Year: 2019, Month: time.January, Day: 2, Start: time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC), Count: 2}, daily[3])
+
+	weekly = GymClasses{startingAt(2018, 12, 30, 6), startingAt(2018, 12, 31, 6)}.Frequency(FrequencyWeekly, time.UTC)
+	assert.Equal(t, []int{2018, 2019}, []int{weekly[0].Year, weekly[1].Year})
+	assert.Equal(t, []int{52, 1}, []int{weekly[0].Week, weekly[1].Week})
+
+	// Classes are counted on the day they start in the location provided
+	auckland := GymClasses{startingAt(2018, 4, 1, 13)}.Frequency(FrequencyDaily, gymLocation)
+	assert.Equal(t, 2, auckland[0].Day)
+
+	assert.Empty(t, GymClasses{}.Frequency(FrequencyWeekly, time.UTC))
+	assert.Empty(t, classes.Frequency("hourly", time.UTC))
+}
+
+// startingAt returns a class which only has its start set, on the hour given in UTC
+func startingAt(year int, month time.Month, day int, hour int) GymClass {
+	return GymClass{StartDateTime: time.Date(year, month, day, hour, 0, 0, 0, time.UTC)}
+}
+
+func frequencyCounts(f []WorkOutFrequency) []int {
+	counts := make([]int, len(f))
+	for i, w := range f {
+		counts[i] = w.Count
+	}
+	return counts
+}
+
+func TestConsistency(t *testing.T) {
+	now := time.Date(2018, 4, 11, 12, 0, 0, 0, time.UTC)
+	classes := GymClasses{
+		// Three classes in the week of 19 March meets the target
+		startingAt(2018, 3, 19, 6), startingAt(2018, 3, 20, 6), startingAt(2018, 3, 21, 6),
+		startingAt(2018, 3, 26, 6), startingAt(2018, 3, 28, 6),
+		startingAt(2018, 4, 2, 6), startingAt(2018, 4, 3, 6), startingAt(2018, 4, 4, 6), startingAt(2018, 4, 4, 6),
+		// This week's target hasn't been met yet
+		startingAt(2018, 4, 10, 6), startingAt(2018, 4, 11, 6),
+	}
+	c := classes.Consistency(now, 3)
+	assert.Equal(t, 2, c.CurrentStreak)
+	assert.Equal(t, 3, c.LongestStreak)
+	assert.Equal(t, 3, c.WeeklyTarget)
+	assert.Equal(t, 1, c.CurrentWeekStreak)
+	assert.Equal(t, 1, c.LongestWeekStreak)
+	assert.InDelta(t, 23.0/9.0, c.AverageGap, 0.0001)
+	assert.Equal(t, 5, c.LongestBreakDays)
+	assert.Equal(t, Interval{Start: time.Date(2018, 4, 5, 0, 0, 0, 0, time.UTC), End: time.Date(2018, 4, 10, 0, 0, 0, 0, time.UTC)}, c.LongestBreak)
+	if assert.Len(t, c.Scores, len(ConsistencyWindows)) {
+		for i, score := range c.Scores {
+			assert.Equal(t, ConsistencyWindows[i], score.Weeks)
+			// Only the three weeks since the first class are counted
+			assert.InDelta(t, 2.0/3.0, score.Score, 0.0001)
+		}
+	}
+
+	// A break that is still going on counts towards the longest break
+	c = GymClasses{startingAt(2018, 3, 1, 6)}.Consistency(now, 3)
+	assert.Equal(t, 0, c.CurrentStreak)
+	assert.Equal(t, 1, c.LongestStreak)
+	assert.Equal(t, 0, c.CurrentWeekStreak)
+	assert.Equal(t, 40, c.LongestBreakDays)
+	assert.Equal(t, Interval{Start: time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), End: now}, c.LongestBreak)
+
+	c = GymClasses{}.Consistency(now, 3)
+	assert.Equal(t, 0, c.LongestStreak)
+	assert.Empty(t, c.Scores)
+}
+
+func TestWeekNumber(t *testing.T) {
+	monday := dayNumber(time.Date(2018, 4, 9, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, weekNumber(monday), weekNumber(monday+6), "Sunday is in the same week as Monday")
+	assert.Equal(t, weekNumber(monday)-1, weekNumber(monday-1))
+	assert.Equal(t, weekNumber(-4)+1, weekNumber(-3), "1969-12-29 was a Monday")
+	assert.Equal(t, weekNumber(-10), weekNumber(-4))
+}
+
+func TestRates(t *testing.T) {
+	now := time.Date(2018, 4, 11, 12, 0, 0, 0, time.UTC)
+	var classes GymClasses
+	// Eight classes in the last four weeks and sixteen in the eight weeks before them
+	for day := 16; day <= 37; day += 3 {
+		classes = append(classes, startingAt(2018, 3, day, 6))
+	}
+	for day := 18; day <= 63; day += 3 {
+		classes = append(classes, startingAt(2018, 1, day, 6))
+	}
+	classes = append(classes, startingAt(2018, 1, 15, 6), startingAt(2018, 4, 12, 6))
+
+	rates := classes.Rates(now, 4, 12)
+	if assert.Len(t, rates, 3) {
+		assert.Equal(t, Rate{Weeks: 4, Classes: 8, PerWeek: 2, PerMonth: 2 * weeksPerMonth, Trend: TrendSteady}, rates[0])
+		assert.Equal(t, Rate{Weeks: 12, Classes: 24, PerWeek: 2, PerMonth: 2 * weeksPerMonth, Trend: TrendSteady}, rates[1])
+		// All time runs from 15 January until the class tomorrow
+		assert.Equal(t, 0, rates[2].Weeks)
+		assert.Equal(t, 26, rates[2].Classes)
+		assert.InDelta(t, 26/(87.0/7.0), rates[2].PerWeek, 0.0001)
+		assert.Equal(t, "", rates[2].Trend)
+	}
+
+	// A new user's rate isn't spread over weeks before they started
+	rates = GymClasses{startingAt(2018, 4, 2, 6), startingAt(2018, 4, 4, 6), startingAt(2018, 4, 6, 6), startingAt(2018, 4, 9, 6)}.Rates(now, 4, 12)
+	assert.InDelta(t, 4/(9.25/7.0), rates[0].PerWeek, 0.0001)
+	assert.Equal(t, rates[0].PerWeek, rates[1].PerWeek)
+	assert.Equal(t, TrendSteady, rates[0].Trend)
+
+	rates = GymClasses{}.Rates(now, RateWindows...)
+	assert.Len(t, rates, len(RateWindows)+1)
+	assert.Equal(t, 0.0, rates[len(rates)-1].PerWeek)
+}
+
+func TestTrend(t *testing.T) {
+	var tests = []struct {
+		recent   float64
+		longer   float64
+		expected string
+	}{
+		{3, 2, TrendUp},
+		{1, 2, TrendDown},
+		{2.1, 2, TrendSteady},
+		{1.9, 2, TrendSteady},
+		{1, 0, TrendUp},
+		{0, 0, TrendSteady},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, trend(test.recent, test.longer), "%v compared with %v", test.recent, test.longer)
+	}
+}
+
+func TestPerWeek(t *testing.T) {
+	assert.Equal(t, 4.0, GymClasses{startingAt(2030, 1, 1, 6), startingAt(2030, 1, 1, 6), startingAt(2030, 1, 2, 6), startingAt(2030, 1, 3, 6)}.PerWeek())
+	assert.Equal(t, 1.5, GymClasses{startingAt(2030, 1, 15, 6), startingAt(2030, 1, 1, 6), startingAt(2030, 1, 8, 6)}.PerWeek())
+	assert.Equal(t, 0.0, GymClasses{}.PerWeek())
+	// Classes in the future are still the oldest
+	assert.Equal(t, startingAt(2030, 1, 1, 6), GymClasses{startingAt(2030, 1, 8, 6), startingAt(2030, 1, 1, 6), startingAt(2030, 1, 15, 6)}.OldestClass())
+	assert.Equal(t, GymClass{}, GymClasses{}.OldestClass())
+}
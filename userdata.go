@@ -0,0 +1,150 @@
+package lm
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// UserData is everything stored about a user, for when they ask for a copy of their data
+type UserData struct {
+	Exported      time.Time      `json:"exported"`
+	User          User           `json:"user"`
+	Classes       GymClasses     `json:"classes"`
+	Attendance    []Attendance   `json:"attendance"`
+	Statistics    UserStatistics `json:"statistics"`
+	Preferences   UserPreference `json:"preferences"`
+	Profile       UserProfile    `json:"profile"`
+	SavedSearches []SavedSearch  `json:"savedSearches"`
+}
+
+// GetUserData gathers everything stored about a user, including anything stored under their ID without a User
+func GetUserData(user string, dbConfig *Config) (UserData, error) {
+	var data UserData
+	var err error
+	data.Exported = dbConfig.now()
+	err = dbConfig.DB.One("ID", user, &data.User)
+	if err == storm.ErrNotFound {
+		data.User = User{ID: user}
+	} else if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to get user")
+		return UserData{}, err
+	}
+	data.Attendance, err = queryAttendance(user, dbConfig)
+	if err != nil {
+		return UserData{}, err
+	}
+	data.Classes, err = QueryUserClasses(user, dbConfig)
+	if err != nil {
+		return UserData{}, err
+	}
+	data.Statistics, err = QueryUserStatistics(user, dbConfig)
+	if err != nil {
+		return UserData{}, err
+	}
+	data.Preferences, err = QueryUserPreferences(user, dbConfig)
+	if err != nil {
+		return UserData{}, err
+	}
+	data.Preferences.User = user
+	data.Profile, err = GetUserProfile(user, dbConfig)
+	if err != nil {
+		return UserData{}, err
+	}
+	data.SavedSearches, err = QuerySavedSearches(user, dbConfig)
+	if err != nil {
+		return UserData{}, err
+	}
+	return data, nil
+}
+
+// ExportUserData writes everything stored about a user to w as a single JSON document
+func ExportUserData(w io.Writer, user string, dbConfig *Config) error {
+	data, err := GetUserData(user, dbConfig)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(data)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to write user data")
+		return err
+	}
+	log.Infof("Exported data for user %s", user)
+	return nil
+}
+
+// ExportUserDataZIP writes everything stored about a user to w as a ZIP file, containing the JSON document
+// written by ExportUserData along with CSV files of their details, classes, attendance and saved searches
+func ExportUserDataZIP(w io.Writer, user string, dbConfig *Config) error {
+	data, err := GetUserData(user, dbConfig)
+	if err != nil {
+		return err
+	}
+	z := zip.NewWriter(w)
+	err = writeUserDataZIP(z, data)
+	if err != nil {
+		z.Close()
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to write user data ZIP")
+		return err
+	}
+	err = z.Close()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user}).Error("Failed to write user data ZIP")
+		return err
+	}
+	log.Infof("Exported data for user %s as a ZIP", user)
+	return nil
+}
+
+func writeUserDataZIP(z *zip.Writer, data UserData) error {
+	f, err := z.Create("user-data.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(data)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name   string
+		header []string
+		rows   [][]string
+	}{
+		{csvFiles[RecordUser], csvHeaders[RecordUser], [][]string{formatCSVRecord(data.User)}},
+		{csvFiles[RecordClass], csvHeaders[RecordClass], nil},
+		{csvFiles[RecordAttendance], csvHeaders[RecordAttendance], nil},
+		{csvFiles[RecordSavedSearch], csvHeaders[RecordSavedSearch], nil},
+	}
+	for _, c := range data.Classes {
+		files[1].rows = append(files[1].rows, formatCSVRecord(c))
+	}
+	for _, a := range data.Attendance {
+		files[2].rows = append(files[2].rows, formatCSVRecord(a))
+	}
+	for _, s := range data.SavedSearches {
+		files[3].rows = append(files[3].rows, formatCSVRecord(s))
+	}
+	for _, file := range files {
+		f, err := z.Create(file.name)
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		cw.Write(file.header)
+		cw.WriteAll(file.rows)
+		if err = cw.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package lm
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportUserData(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	defer testConfig.DB.Close()
+	storeExportData(t, testConfig)
+	_, err = StoreSavedSearch(SavedSearch{UserID: testUser.ID, Name: "RPM", Text: "rpm after:now before:+7d"}, testConfig)
+	assert.NoError(t, err)
+
+	var exported bytes.Buffer
+	err = ExportUserData(&exported, testUser.ID, testConfig)
+	assert.NoError(t, err)
+	var data UserData
+	err = json.Unmarshal(exported.Bytes(), &data)
+	assert.NoError(t, err, "Failed to parse user data")
+	assert.Equal(t, testUser.ID, data.User.ID)
+	assert.Len(t, data.Classes, 3)
+	assert.Len(t, data.Attendance, 3)
+	assert.Equal(t, 3, data.Statistics.TotalClasses)
+	assert.Equal(t, testUser.ID, data.Preferences.User)
+	assert.Len(t, data.SavedSearches, 2)
+
+	var zipped bytes.Buffer
+	err = ExportUserDataZIP(&zipped, testUser.ID, testConfig)
+	assert.NoError(t, err)
+	z, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	if !assert.NoError(t, err, "Failed to read ZIP") {
+		return
+	}
+	// Each CSV has a header row
+	expectedRows := map[string]int{"user-data.json": 0, "users.csv": 2, "classes.csv": 4, "attendance.csv": 4, "saved_searches.csv": 3}
+	for _, f := range z.File {
+		expected, ok := expectedRows[f.Name]
+		assert.True(t, ok, "Unexpected file %s in ZIP", f.Name)
+		delete(expectedRows, f.Name)
+		if expected == 0 {
+			continue
+		}
+		r, err := f.Open()
+		assert.NoError(t, err)
+		rows, err := csv.NewReader(r).ReadAll()
+		r.Close()
+		assert.NoError(t, err)
+		assert.Len(t, rows, expected, f.Name)
+	}
+	assert.Empty(t, expectedRows, "Files missing from ZIP")
+
+	// Data stored under an ID without a User is still exported
+	assert.NoError(t, StoreUserProfile(UserProfile{UserID: "999", HomeGym: "city"}, testConfig))
+	_, err = StoreSavedSearch(SavedSearch{UserID: "999", Name: "RPM", Text: "rpm"}, testConfig)
+	assert.NoError(t, err)
+	data, err = GetUserData("999", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "999", data.User.ID)
+	assert.Equal(t, "city", data.Profile.HomeGym)
+	assert.Len(t, data.SavedSearches, 1)
+
+	data, err = GetUserData("unknown", testConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, data.Attendance)
+}
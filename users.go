@@ -0,0 +1,182 @@
+package lm
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/asdine/storm"
+)
+
+// UserUpdate describes changes to a user, only the fields which are set are changed
+type UserUpdate struct {
+	Name      *string `json:"name,omitempty"`
+	FirstName *string `json:"given_name,omitempty"`
+	LastName  *string `json:"family_name,omitempty"`
+	NickName  *string `json:"nickname,omitempty"`
+	Gender    *string `json:"gender,omitempty"`
+	Email     *string `json:"email,omitempty"`
+	Verified  *bool   `json:"verified,omitempty"`
+	Locale    *string `json:"locale,omitempty"`
+}
+
+// GetUser returns the user with the ID provided
+func GetUser(id string, dbConfig *Config) (User, error) {
+	var user User
+	err := dbConfig.DB.One("ID", id, &user)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to get user")
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns the user with the email address provided, ignoring case
+func GetUserByEmail(email string, dbConfig *Config) (User, error) {
+	var user User
+	err := dbConfig.DB.One("Email", normaliseEmail(email), &user)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to get user by email")
+		return User{}, err
+	}
+	return user, nil
+}
+
+// UpdateUser changes the fields of a user that are set in update and returns the updated user
+func UpdateUser(id string, update UserUpdate, dbConfig *Config) (User, error) {
+	user, err := GetUser(id, dbConfig)
+	if err != nil {
+		return User{}, err
+	}
+	setString := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	setString(&user.Name, update.Name)
+	setString(&user.FirstName, update.FirstName)
+	setString(&user.LastName, update.LastName)
+	setString(&user.NickName, update.NickName)
+	setString(&user.Gender, update.Gender)
+	setString(&user.Email, update.Email)
+	setString(&user.Locale, update.Locale)
+	if update.Verified != nil {
+		user.Verified = *update.Verified
+	}
+	user.LastUpdated = dbConfig.now()
+
+	err = StoreUser(user, dbConfig)
+	if err != nil {
+		return User{}, err
+	}
+	return GetUser(id, dbConfig)
+}
+
+// DeleteUser deletes a user along with their attendance, saved searches and profile, including any stored
+// without a User. Deleting a user who has nothing stored does nothing
+// Everything is deleted in a single transaction so nothing is left behind if it fails
+func DeleteUser(id string, dbConfig *Config) error {
+	tx, err := dbConfig.DB.Begin(true)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to start transaction to delete user")
+		return err
+	}
+	defer tx.Rollback()
+
+	// Classes, saved searches and profiles can be stored without a User so they are deleted either way
+	var user User
+	err = tx.One("ID", id, &user)
+	if err == nil {
+		err = tx.DeleteStruct(&user)
+	}
+	if err != nil && err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to delete user")
+		return err
+	}
+
+	var attendance []Attendance
+	err = tx.Find("UserID", id, &attendance)
+	if err != nil && err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to find attendance to delete")
+		return err
+	}
+	for i := range attendance {
+		err = tx.DeleteStruct(&attendance[i])
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to delete attendance")
+			return err
+		}
+	}
+
+	var searches []SavedSearch
+	err = tx.Find("UserID", id, &searches)
+	if err != nil && err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to find saved searches to delete")
+		return err
+	}
+	for i := range searches {
+		err = tx.DeleteStruct(&searches[i])
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to delete saved search")
+			return err
+		}
+	}
+
+	var profile UserProfile
+	err = tx.One("UserID", id, &profile)
+	if err == nil {
+		err = tx.DeleteStruct(&profile)
+	}
+	if err != nil && err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to delete user profile")
+		return err
+	}
+
+	// Classes from before attendance was stored separately
+	var legacy UserGymClass
+	err = tx.One("UserID", id, &legacy)
+	if err == nil {
+		err = tx.DeleteStruct(&legacy)
+	}
+	if err != nil && err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to delete user classes")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": id}).Error("Failed to commit deleting user")
+		return err
+	}
+	log.Infof("Deleted user %s with %d classes and %d saved searches", id, len(attendance), len(searches))
+	return nil
+}
+
+// validateUser checks the required fields of a user are set and normalises their email address
+func validateUser(user *User, dbConfig *Config) error {
+	if strings.TrimSpace(user.ID) == "" {
+		return errors.New("User must have an ID")
+	}
+	user.Email = normaliseEmail(user.Email)
+	if user.Email == "" {
+		return nil
+	}
+	address, err := mail.ParseAddress(user.Email)
+	if err != nil || address.Address != user.Email {
+		return errors.New("Invalid email address " + user.Email)
+	}
+	var existing User
+	err = dbConfig.DB.One("Email", user.Email, &existing)
+	if err == nil && existing.ID != user.ID {
+		return errors.New("Email address " + user.Email + " is already used by another user")
+	} else if err != nil && err != storm.ErrNotFound {
+		log.WithFields(log.Fields{"error": err}).Error("Failed to check for users with the same email address")
+		return err
+	}
+	return nil
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,92 @@
+package lm
+
+import (
+	"testing"
+
+	"github.com/asdine/storm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserManagement(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	defer testConfig.DB.Close()
+	storeExportData(t, testConfig)
+	other := User{ID: "456", Name: "John Smith", Email: "John@Example.com "}
+	assert.NoError(t, StoreUser(other, testConfig))
+	_, err = StoreSavedSearch(SavedSearch{UserID: testUser.ID, Name: "RPM", Text: "rpm"}, testConfig)
+	assert.NoError(t, err)
+
+	// Validation
+	assert.Error(t, StoreUser(User{Name: "No ID"}, testConfig), "Expected an error for a user without an ID")
+	assert.Error(t, StoreUser(User{ID: "789", Email: "not an email"}, testConfig), "Expected an error for an invalid email")
+	assert.Error(t, StoreUser(User{ID: "789", Email: "JANE@example.com"}, testConfig), "Expected an error for a duplicate email")
+	assert.NoError(t, StoreUser(User{ID: "789"}, testConfig), "Email addresses are optional")
+
+	user, err := GetUser(testUser.ID, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, testUser.Name, user.Name)
+	_, err = GetUser("unknown", testConfig)
+	assert.Error(t, err)
+
+	user, err = GetUserByEmail(" JOHN@example.com", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "456", user.ID)
+	assert.Equal(t, "john@example.com", user.Email)
+
+	// Only the fields provided are updated
+	nickName := "janey"
+	email := "jane.smith@example.com"
+	user, err = UpdateUser(testUser.ID, UserUpdate{NickName: &nickName, Email: &email}, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, nickName, user.NickName)
+	assert.Equal(t, email, user.Email)
+	assert.Equal(t, testUser.Name, user.Name)
+	assert.True(t, user.LastUpdated.After(testUser.LastUpdated))
+	user, err = GetUserByEmail(email, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, testUser.ID, user.ID)
+	_, err = GetUserByEmail(testUser.Email, testConfig)
+	assert.Error(t, err, "The old email address should no longer find the user")
+	taken := "john@example.com"
+	_, err = UpdateUser(testUser.ID, UserUpdate{Email: &taken}, testConfig)
+	assert.Error(t, err, "Expected an error updating to another user's email")
+
+	// Deleting a user deletes everything stored for them
+	assert.NoError(t, StoreUserProfile(UserProfile{UserID: testUser.ID, HomeGym: "city"}, testConfig))
+	assert.NoError(t, DeleteUser(testUser.ID, testConfig))
+	_, err = GetUser(testUser.ID, testConfig)
+	assert.Equal(t, storm.ErrNotFound, err)
+	classes, err := QueryUserClasses(testUser.ID, testConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, classes)
+	searches, err := QuerySavedSearches(testUser.ID, testConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, searches)
+	profile, err := GetUserProfile(testUser.ID, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "", profile.HomeGym)
+	_, err = GetUser("456", testConfig)
+	assert.NoError(t, err, "Other users should not be deleted")
+	assert.NoError(t, DeleteUser(testUser.ID, testConfig), "Deleting a user twice does nothing")
+
+	// Everything stored under an ID is deleted even without a User
+	assert.NoError(t, StoreUserProfile(UserProfile{UserID: "999", HomeGym: "city"}, testConfig))
+	_, err = StoreUserClassStatus("999", testClasses[0].UUID, AttendancePlanned, testConfig)
+	assert.NoError(t, err)
+	_, err = StoreSavedSearch(SavedSearch{UserID: "999", Name: "RPM", Text: "rpm"}, testConfig)
+	assert.NoError(t, err)
+	assert.NoError(t, DeleteUser("999", testConfig))
+	classes, err = QueryUserClasses("999", testConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, classes)
+	searches, err = QuerySavedSearches("999", testConfig)
+	assert.NoError(t, err)
+	assert.Empty(t, searches)
+	profile, err = GetUserProfile("999", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "", profile.HomeGym)
+}
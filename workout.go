@@ -0,0 +1,148 @@
+package lm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Common metrics recorded against a Workout, any other name can also be used
+const (
+	MetricWeight   = "weight_kg"
+	MetricDistance = "distance_km"
+	MetricWatts    = "watts"
+)
+
+// Workout describes how a class the user attended went. Rating is between 1 - 5 and RPE, the rate of
+// perceived exertion, is between 1 - 10. Either is zero when it hasn't been given
+// Metrics holds any measurements from the class, such as the weights used in BODYPUMP or the distance in RPM
+type Workout struct {
+	Rating  int                `json:"rating,omitempty"`
+	RPE     int                `json:"rpe,omitempty"`
+	Notes   string             `json:"notes,omitempty"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// RatingAverage describes the average rating given to classes which share a class name, instructor or gym
+type RatingAverage struct {
+	Key     string  `json:"key"`
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// RateUserClass stores a rating, perceived exertion, notes and metrics against a class the user attended,
+// replacing any that were stored before
+func RateUserClass(user string, classID string, workout Workout, dbConfig *Config) (Attendance, error) {
+	err := validateWorkout(workout)
+	if err != nil {
+		return Attendance{}, err
+	}
+	var a Attendance
+	err = dbConfig.DB.One("ID", attendanceID(user, classID), &a)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user, "class": classID}).Error("Failed to find user class when rating it")
+		return Attendance{}, err
+	}
+	if a.Status != AttendanceAttended {
+		return Attendance{}, fmt.Errorf("Unable to rate a class which is %s, only attended classes can be rated", a.Status)
+	}
+	a.Workout = workout
+	err = dbConfig.DB.Save(&a)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "user": user, "class": classID}).Error("Failed to store rating for user class")
+		return Attendance{}, err
+	}
+	log.Infof("Rated class %s for user %s", classID, user)
+	return a, nil
+}
+
+func validateWorkout(w Workout) error {
+	if w.Rating < 0 || w.Rating > 5 {
+		return fmt.Errorf("Rating must be between 1 and 5, not %d", w.Rating)
+	}
+	if w.RPE < 0 || w.RPE > 10 {
+		return fmt.Errorf("RPE must be between 1 and 10, not %d", w.RPE)
+	}
+	for name, value := range w.Metrics {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("Metrics must have a name")
+		}
+		if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("Metric %s must be a positive number, not %v", name, value)
+		}
+	}
+	return nil
+}
+
+// averageRating returns the average rating of the attended classes a user has rated, and how many they rated
+func averageRating(attendance []Attendance) (float64, int) {
+	total, count := 0, 0
+	for _, a := range attendance {
+		if a.Status == AttendanceAttended && a.Workout.Rating > 0 {
+			total += a.Workout.Rating
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return float64(total) / float64(count), count
+}
+
+// averageRatings returns the average rating of the rated classes for each class name, instructor and gym
+// Classes without an instructor are left out of the instructor averages
+func averageRatings(attendance []Attendance, classes GymClasses) (byClass, byInstructor, byGym []RatingAverage) {
+	classesByID := make(map[string]GymClass, len(classes))
+	for _, c := range classes {
+		classesByID[c.UUID] = c
+	}
+	classTotals := map[string]*RatingAverage{}
+	instructorTotals := map[string]*RatingAverage{}
+	gymTotals := map[string]*RatingAverage{}
+	for _, a := range attendance {
+		c, ok := classesByID[a.ClassUUID]
+		if !ok || a.Status != AttendanceAttended || a.Workout.Rating == 0 {
+			continue
+		}
+		addRating(classTotals, c.Name, a.Workout.Rating)
+		addRating(instructorTotals, c.Instructor, a.Workout.Rating)
+		addRating(gymTotals, c.Gym, a.Workout.Rating)
+	}
+	return sortedRatings(classTotals), sortedRatings(instructorTotals), sortedRatings(gymTotals)
+}
+
+// addRating adds a rating to the running total for key, which is stored in Average until sortedRatings
+func addRating(totals map[string]*RatingAverage, key string, rating int) {
+	if key == "" {
+		return
+	}
+	r, ok := totals[key]
+	if !ok {
+		r = &RatingAverage{Key: key}
+		totals[key] = r
+	}
+	r.Average += float64(rating)
+	r.Count++
+}
+
+// sortedRatings turns running totals into averages, sorted from the highest rated with ties going to the
+// most rated
+func sortedRatings(totals map[string]*RatingAverage) []RatingAverage {
+	ratings := make([]RatingAverage, 0, len(totals))
+	for _, r := range totals {
+		ratings = append(ratings, RatingAverage{Key: r.Key, Average: r.Average / float64(r.Count), Count: r.Count})
+	}
+	sort.Slice(ratings, func(i, j int) bool {
+		if ratings[i].Average != ratings[j].Average {
+			return ratings[i].Average > ratings[j].Average
+		}
+		if ratings[i].Count != ratings[j].Count {
+			return ratings[i].Count > ratings[j].Count
+		}
+		return ratings[i].Key < ratings[j].Key
+	})
+	return ratings
+}
@@ -0,0 +1,109 @@
+package lm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWorkout(t *testing.T) {
+	var tests = []struct {
+		workout Workout
+		valid   bool
+	}{
+		{Workout{}, true},
+		{Workout{Rating: 5, RPE: 8, Notes: "New PB", Metrics: map[string]float64{MetricWeight: 22.5}}, true},
+		{Workout{Rating: 1, RPE: 1}, true},
+		{Workout{Rating: 6}, false},
+		{Workout{Rating: -1}, false},
+		{Workout{RPE: 11}, false},
+		{Workout{Metrics: map[string]float64{"": 1}}, false},
+		{Workout{Metrics: map[string]float64{MetricWatts: -200}}, false},
+		{Workout{Metrics: map[string]float64{MetricDistance: math.NaN()}}, false},
+	}
+	for _, test := range tests {
+		err := validateWorkout(test.workout)
+		if test.valid {
+			assert.NoError(t, err, "Expected %v to be valid", test.workout)
+		} else {
+			assert.Error(t, err, "Expected %v to be invalid", test.workout)
+		}
+	}
+}
+
+func TestAverageRatings(t *testing.T) {
+	classes := GymClasses{
+		{UUID: "1", Gym: "city", Name: "RPM", Instructor: "Jane"},
+		{UUID: "2", Gym: "city", Name: "RPM", Instructor: "Sam"},
+		{UUID: "3", Gym: "takapuna", Name: "BODYPUMP", Instructor: "Jane"},
+		{UUID: "4", Gym: "takapuna", Name: "BODYPUMP"},
+		{UUID: "5", Gym: "city", Name: "YOGA", Instructor: "Sam"},
+	}
+	attendance := []Attendance{
+		{ClassUUID: "1", Status: AttendanceAttended, Workout: Workout{Rating: 5}},
+		{ClassUUID: "2", Status: AttendanceAttended, Workout: Workout{Rating: 3}},
+		{ClassUUID: "3", Status: AttendanceAttended, Workout: Workout{Rating: 4}},
+		{ClassUUID: "4", Status: AttendanceAttended, Workout: Workout{Rating: 4}},
+		// Unrated and missed classes are left out
+		{ClassUUID: "5", Status: AttendanceAttended, Workout: Workout{Notes: "Too hot"}},
+		{ClassUUID: "5", Status: AttendanceNoShow, Workout: Workout{Rating: 1}},
+	}
+
+	average, count := averageRating(attendance)
+	assert.Equal(t, 4.0, average)
+	assert.Equal(t, 4, count)
+
+	byClass, byInstructor, byGym := averageRatings(attendance, classes)
+	assert.Equal(t, []RatingAverage{{"BODYPUMP", 4, 2}, {"RPM", 4, 2}}, byClass)
+	assert.Equal(t, []RatingAverage{{"Jane", 4.5, 2}, {"Sam", 3, 1}}, byInstructor)
+	assert.Equal(t, []RatingAverage{{"city", 4, 2}, {"takapuna", 4, 2}}, byGym)
+
+	average, count = averageRating(nil)
+	assert.Equal(t, 0.0, average)
+	assert.Equal(t, 0, count)
+}
+
+func TestRateUserClass(t *testing.T) {
+	testConfig, err := NewConfig()
+	if err != nil {
+		t.Errorf("Failed to create database %s", err)
+		return
+	}
+	err = clearDB(testConfig)
+	if err != nil {
+		t.Errorf("Failed to clear database %s", err)
+	}
+	defer testConfig.DB.Close()
+	_, err = StoreClasses(testClasses, testConfig)
+	if err != nil {
+		t.Errorf("Error when storing classes %s", err)
+	}
+	_, err = StoreUserClass("123", testClasses[0].UUID, testConfig)
+	assert.NoError(t, err)
+	_, err = StoreUserClass("123", testClasses[1].UUID, testConfig)
+	assert.NoError(t, err)
+	_, err = StoreUserClassStatus("123", testClasses[2].UUID, AttendancePlanned, testConfig)
+	assert.NoError(t, err)
+
+	workout := Workout{Rating: 4, RPE: 7, Notes: "Legs were sore", Metrics: map[string]float64{MetricDistance: 18.2, MetricWatts: 210}}
+	a, err := RateUserClass("123", testClasses[0].UUID, workout, testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, workout, a.Workout)
+	_, err = RateUserClass("123", testClasses[1].UUID, Workout{Rating: 2}, testConfig)
+	assert.NoError(t, err)
+
+	_, err = RateUserClass("123", testClasses[2].UUID, Workout{Rating: 5}, testConfig)
+	assert.Error(t, err, "Expected an error rating a class which hasn't been attended")
+	_, err = RateUserClass("123", testClasses[0].UUID, Workout{Rating: 9}, testConfig)
+	assert.Error(t, err, "Expected an error for a rating out of range")
+	_, err = RateUserClass("123", testClasses[5].UUID, Workout{Rating: 3}, testConfig)
+	assert.Error(t, err, "Expected an error for a class the user doesn't have")
+
+	stats, err := QueryUserStatistics("123", testConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, stats.AverageRating)
+	assert.Equal(t, 2, stats.RatedClasses)
+	assert.NotEmpty(t, stats.ClassRatings)
+	assert.NotEmpty(t, stats.GymRatings)
+}